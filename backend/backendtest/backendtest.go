@@ -0,0 +1,170 @@
+// Package backendtest provides a conformance test of the ons state backends:
+// objects are stored, fetched and deleted and the state is locked by a single
+// holder at a time.
+package backendtest
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/thbkrkr/ons/client"
+)
+
+// Run tests the objects and the lock of an empty state backend
+func Run(t *testing.T, b client.StateBackend) {
+	testObjects(t, b)
+	testLock(t, b)
+}
+
+func testObjects(t *testing.T, b client.StateBackend) {
+	data, err := b.Get(client.StateName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data != nil {
+		t.Fatalf("expected no state, got %q", data)
+	}
+
+	for _, name := range []string{client.StateName, "snapshots/ons.state.20171118T100000Z.json"} {
+		state := []byte(`{"records":{"bada.boum":[]}}`)
+		err = b.Put(name, state)
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err = b.Get(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(data, state) {
+			t.Errorf("%s: expected %q, got %q", name, state, data)
+		}
+
+		state = []byte(`{"records":{}}`)
+		err = b.Put(name, state)
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err = b.Get(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(data, state) {
+			t.Errorf("%s: expected %q after replace, got %q", name, state, data)
+		}
+
+		err = b.Delete(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err = b.Get(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if data != nil {
+			t.Errorf("%s: expected no object after delete, got %q", name, data)
+		}
+
+		// Deleting a missing object is not an error
+		err = b.Delete(name)
+		if err != nil {
+			t.Errorf("%s: expected no error deleting a missing object, got %s", name, err)
+		}
+	}
+}
+
+func testLock(t *testing.T, b client.StateBackend) {
+	first := &client.LockInfo{ID: "11111111-1111-1111-1111-111111111111", Operation: "apply",
+		Who: "bim@bada", Host: "bada", PID: 42, Created: time.Date(2017, 11, 18, 10, 0, 0, 0, time.UTC)}
+	second := &client.LockInfo{ID: "22222222-2222-2222-2222-222222222222", Operation: "rollback",
+		Who: "bam@boum", Host: "boum", PID: 43, Created: time.Date(2017, 11, 18, 10, 1, 0, 0, time.UTC)}
+
+	err := b.Unlock(first.ID)
+	if err == nil || !strings.Contains(err.Error(), "not locked") {
+		t.Errorf("expected a not locked error, got %v", err)
+	}
+
+	err = b.Lock(first)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = b.Lock(second)
+	lockedErr, ok := err.(*client.LockedError)
+	if !ok {
+		t.Fatalf("expected a *client.LockedError, got %#v", err)
+	}
+	if lockedErr.Holder == nil {
+		t.Fatal("expected the holder of the lock, got nil")
+	}
+	if *lockedErr.Holder != *first {
+		t.Errorf("expected holder %+v, got %+v", *first, *lockedErr.Holder)
+	}
+
+	err = b.Unlock(second.ID)
+	if err == nil || !strings.Contains(err.Error(), "does not match the lock ID `"+first.ID+"`") {
+		t.Errorf("expected a lock ID mismatch error, got %v", err)
+	}
+
+	// The lock is still held after a mismatching unlock
+	err = b.Lock(second)
+	if _, ok := err.(*client.LockedError); !ok {
+		t.Errorf("expected the state to be still locked, got %v", err)
+	}
+
+	err = b.Unlock(first.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = b.Lock(second)
+	if err != nil {
+		t.Fatalf("expected to lock an unlocked state, got %s", err)
+	}
+	err = b.Unlock(second.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// RunInvalidLock tests that a lock object that can not be decoded, left by a
+// crash while locking, is taken over and removed by an unlock whatever its ID.
+// The backend must store its lock in the object lockName.
+func RunInvalidLock(t *testing.T, b client.StateBackend, lockName string) {
+	err := b.Put(lockName, []byte(`{"id": "11111111-11`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = b.Lock(&client.LockInfo{ID: "22222222-2222-2222-2222-222222222222"})
+	lockedErr, ok := err.(*client.LockedError)
+	if !ok || !lockedErr.Invalid {
+		t.Fatalf("expected an invalid lock error, got %#v", err)
+	}
+
+	lock, err := client.AcquireLock(b, "apply")
+	if err != nil {
+		t.Fatalf("expected the invalid lock to be taken over, got %s", err)
+	}
+	err = lock.Release()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = b.Put(lockName, []byte{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = b.Unlock("invalid")
+	if err != nil {
+		t.Fatalf("expected the invalid lock to be removed, got %s", err)
+	}
+	data, err := b.Get(lockName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data != nil {
+		t.Errorf("expected no lock, got %q", data)
+	}
+}
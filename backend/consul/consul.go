@@ -0,0 +1,241 @@
+// Package consul implements an ons state backend storing the state in the
+// key/value store of Consul, or of any store implementing its KV HTTP API.
+package consul
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/thbkrkr/ons/client"
+)
+
+// DefaultTimeout is the timeout of the requests to Consul
+const DefaultTimeout = 30 * time.Second
+
+// lockName is the name of the key locking the state
+const lockName = "ons.lock"
+
+// Backend stores the objects under a key prefix. The state is locked by a lock
+// key created with a check-and-set operation that only succeeds if the key
+// does not exist.
+type Backend struct {
+	address *url.URL
+	prefix  string
+	token   string
+	client  *http.Client
+}
+
+// kvPair represents a key of the KV store with its metadata
+type kvPair struct {
+	ModifyIndex uint64 `json:"ModifyIndex"`
+	Value       []byte `json:"Value"`
+}
+
+// NewBackend creates a new Consul backend given the address of the HTTP API
+// (http://127.0.0.1:8500), a key prefix and an ACL token
+func NewBackend(address string, prefix string, token string) (*Backend, error) {
+	u, err := url.Parse(strings.TrimSuffix(address, "/"))
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("Invalid Consul address `%s`", address)
+	}
+	if strings.Trim(prefix, "/") == "" {
+		return nil, fmt.Errorf("Consul key prefix not set")
+	}
+
+	return &Backend{
+		address: u,
+		prefix:  strings.Trim(prefix, "/"),
+		token:   token,
+		client:  &http.Client{Timeout: DefaultTimeout},
+	}, nil
+}
+
+// Get gets the value of a key
+func (b *Backend) Get(name string) ([]byte, error) {
+	pair, err := b.get(name)
+	if err != nil || pair == nil {
+		return nil, err
+	}
+
+	return pair.Value, nil
+}
+
+// Put sets the value of a key
+func (b *Backend) Put(name string, data []byte) error {
+	ok, err := b.put(name, data, nil)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return fmt.Errorf("Fail to put Consul key `%s`", b.key(name))
+	}
+
+	return nil
+}
+
+// Delete deletes a key
+func (b *Backend) Delete(name string) error {
+	status, body, err := b.do("DELETE", name, nil, nil)
+	if err != nil {
+		return err
+	}
+	if status != http.StatusOK {
+		return responseError("delete", b.key(name), status, body)
+	}
+
+	return nil
+}
+
+// Lock creates the lock key if it does not exist
+func (b *Backend) Lock(info *client.LockInfo) error {
+	data, err := json.Marshal(info)
+	if err != nil {
+		return err
+	}
+
+	ok, err := b.put(lockName, data, url.Values{"cas": {"0"}})
+	if err != nil {
+		return err
+	}
+	if ok {
+		return nil
+	}
+
+	pair, err := b.get(lockName)
+	if err != nil {
+		return err
+	}
+
+	var holder *client.LockInfo
+	if pair != nil {
+		holder = &client.LockInfo{}
+		if json.Unmarshal(pair.Value, holder) != nil {
+			holder = nil
+		}
+	}
+
+	return &client.LockedError{Holder: holder, Invalid: pair != nil && holder == nil}
+}
+
+// Unlock deletes the lock key if its ID matches. An invalid lock key is
+// deleted whatever the ID.
+func (b *Backend) Unlock(id string) error {
+	pair, err := b.get(lockName)
+	if err != nil {
+		return err
+	}
+	if pair == nil {
+		return fmt.Errorf("State not locked")
+	}
+
+	holder := &client.LockInfo{}
+	if json.Unmarshal(pair.Value, holder) == nil && holder.ID != id {
+		return fmt.Errorf("Lock ID `%s` does not match the lock ID `%s`", id, holder.ID)
+	}
+
+	// Only delete the lock key if it has not been modified in the meantime
+	query := url.Values{"cas": {strconv.FormatUint(pair.ModifyIndex, 10)}}
+	status, body, err := b.do("DELETE", lockName, nil, query)
+	if err != nil {
+		return err
+	}
+	if status != http.StatusOK {
+		return responseError("delete", b.key(lockName), status, body)
+	}
+	if strings.TrimSpace(string(body)) != "true" {
+		return fmt.Errorf("Lock `%s` modified while unlocking", b.key(lockName))
+	}
+
+	return nil
+}
+
+func (b *Backend) key(name string) string {
+	return b.prefix + "/" + name
+}
+
+// get gets a key with its metadata. It returns nil if the key does not exist.
+func (b *Backend) get(name string) (*kvPair, error) {
+	status, body, err := b.do("GET", name, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	switch status {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		return nil, nil
+	default:
+		return nil, responseError("get", b.key(name), status, body)
+	}
+
+	var pairs []kvPair
+	err = json.Unmarshal(body, &pairs)
+	if err != nil {
+		return nil, fmt.Errorf("Fail to get Consul key `%s`: %s", b.key(name), err)
+	}
+	if len(pairs) == 0 {
+		return nil, nil
+	}
+
+	return &pairs[0], nil
+}
+
+// put sets the value of a key and returns false if a check-and-set operation failed
+func (b *Backend) put(name string, data []byte, query url.Values) (bool, error) {
+	status, body, err := b.do("PUT", name, data, query)
+	if err != nil {
+		return false, err
+	}
+	if status != http.StatusOK {
+		return false, responseError("put", b.key(name), status, body)
+	}
+
+	return strings.TrimSpace(string(body)) == "true", nil
+}
+
+// do sends a request on a key and returns the status code and the body of the response
+func (b *Backend) do(method string, name string, data []byte, query url.Values) (int, []byte, error) {
+	u := *b.address
+	u.Path += "/v1/kv/" + b.key(name)
+	u.RawQuery = query.Encode()
+
+	req, err := http.NewRequest(method, u.String(), bytes.NewReader(data))
+	if err != nil {
+		return 0, nil, err
+	}
+	if b.token != "" {
+		req.Header.Set("X-Consul-Token", b.token)
+	}
+
+	res, err := b.client.Do(req)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer res.Body.Close()
+
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	return res.StatusCode, body, nil
+}
+
+func responseError(action string, key string, status int, body []byte) error {
+	message := strings.TrimSpace(string(body))
+	if message == "" {
+		message = http.StatusText(status)
+	}
+
+	return fmt.Errorf("Fail to %s Consul key `%s`: HTTP %d: %s", action, key, status, message)
+}
@@ -0,0 +1,168 @@
+package consul
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/thbkrkr/ons/backend/backendtest"
+	"github.com/thbkrkr/ons/client"
+)
+
+// testServer is a Consul KV HTTP API storing the keys in memory. Writes
+// with a cas parameter only succeed if the ModifyIndex of the key matches,
+// 0 meaning that the key must not exist.
+type testServer struct {
+	*httptest.Server
+
+	mu        sync.Mutex
+	keys      map[string]*kvPair
+	index     uint64
+	beforeCAS func()
+}
+
+func newTestServer(t *testing.T) *testServer {
+	s := &testServer{keys: map[string]*kvPair{}}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+
+		if r.Header.Get("X-Consul-Token") != "bim" {
+			http.Error(w, "ACL not found", http.StatusForbidden)
+			return
+		}
+		if !strings.HasPrefix(r.URL.Path, "/v1/kv/") {
+			http.NotFound(w, r)
+			return
+		}
+		key := strings.TrimPrefix(r.URL.Path, "/v1/kv/")
+
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			t.Error(err)
+		}
+
+		pair := s.keys[key]
+		if cas := r.URL.Query().Get("cas"); cas != "" {
+			if s.beforeCAS != nil {
+				s.beforeCAS()
+				pair = s.keys[key]
+			}
+			index, err := strconv.ParseUint(cas, 10, 64)
+			if err != nil {
+				http.Error(w, "Invalid cas", http.StatusBadRequest)
+				return
+			}
+			if (pair == nil && index != 0) || (pair != nil && pair.ModifyIndex != index) {
+				w.Write([]byte("false"))
+				return
+			}
+		}
+
+		switch r.Method {
+		case "GET":
+			if pair == nil {
+				http.NotFound(w, r)
+				return
+			}
+			json.NewEncoder(w).Encode([]*kvPair{pair})
+		case "PUT":
+			s.index++
+			s.keys[key] = &kvPair{ModifyIndex: s.index, Value: body}
+			w.Write([]byte("true"))
+		case "DELETE":
+			delete(s.keys, key)
+			w.Write([]byte("true"))
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	}))
+	return s
+}
+
+func TestBackend(t *testing.T) {
+	s := newTestServer(t)
+	defer s.Close()
+
+	b, err := NewBackend(s.URL, "/ons/bada.boum/", "bim")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	backendtest.Run(t, b)
+	backendtest.RunInvalidLock(t, b, lockName)
+}
+
+func TestKeys(t *testing.T) {
+	s := newTestServer(t)
+	defer s.Close()
+
+	b, err := NewBackend(s.URL, "ons/bada.boum", "bim")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = b.Put(client.StateName, []byte("{}"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = b.Lock(&client.LockInfo{ID: "1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, key := range []string{"ons/bada.boum/ons.state.json", "ons/bada.boum/ons.lock"} {
+		if s.keys[key] == nil {
+			t.Errorf("expected key %s, got %v", key, s.keys)
+		}
+	}
+}
+
+func TestUnlockModifiedLock(t *testing.T) {
+	s := newTestServer(t)
+	defer s.Close()
+
+	b, err := NewBackend(s.URL, "ons", "bim")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = b.Lock(&client.LockInfo{ID: "1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Another process takes over the lock between the read and the delete of the lock key
+	s.beforeCAS = func() {
+		s.index++
+		s.keys["ons/ons.lock"] = &kvPair{ModifyIndex: s.index, Value: []byte(`{"id":"2"}`)}
+	}
+
+	err = b.Unlock("1")
+	if err == nil || !strings.Contains(err.Error(), "modified while unlocking") {
+		t.Errorf("expected a modified lock error, got %v", err)
+	}
+	if s.keys["ons/ons.lock"] == nil {
+		t.Error("expected the lock of the other process to be kept")
+	}
+}
+
+func TestForbidden(t *testing.T) {
+	s := newTestServer(t)
+	defer s.Close()
+
+	b, err := NewBackend(s.URL, "ons", "bam")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = b.Put(client.StateName, []byte("{}"))
+	if err == nil || !strings.Contains(err.Error(), "HTTP 403: ACL not found") {
+		t.Errorf("expected a forbidden error, got %v", err)
+	}
+}
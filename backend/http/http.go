@@ -0,0 +1,180 @@
+// Package http implements an ons state backend storing the state with a REST
+// API: objects are fetched with GET, stored with POST and deleted with DELETE,
+// the state is locked and unlocked with the LOCK and UNLOCK methods.
+package http
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/thbkrkr/ons/client"
+)
+
+// DefaultTimeout is the timeout of the requests to the HTTP server
+const DefaultTimeout = 30 * time.Second
+
+// Backend stores the objects at {address}/{name}. The state is locked by
+// sending the lock information to {address}/ons.state.json with the LOCK method;
+// the server answers 423 Locked or 409 Conflict with the lock information of the
+// holder if the state is already locked. The state is unlocked with the UNLOCK
+// method and the lock information.
+type Backend struct {
+	address  *url.URL
+	username string
+	password string
+	client   *http.Client
+}
+
+// NewBackend creates a new HTTP backend given the base address of the objects.
+// Requests are authenticated with basic authentication if a username is set.
+func NewBackend(address string, username string, password string) (*Backend, error) {
+	u, err := url.Parse(strings.TrimSuffix(address, "/"))
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("Invalid HTTP backend address `%s`", address)
+	}
+
+	return &Backend{
+		address:  u,
+		username: username,
+		password: password,
+		client:   &http.Client{Timeout: DefaultTimeout},
+	}, nil
+}
+
+// Get gets an object
+func (b *Backend) Get(name string) ([]byte, error) {
+	status, body, err := b.do("GET", name, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	switch status {
+	case http.StatusOK:
+		return body, nil
+	case http.StatusNotFound, http.StatusNoContent:
+		return nil, nil
+	}
+
+	return nil, responseError("get", name, status, body)
+}
+
+// Put creates or replaces an object
+func (b *Backend) Put(name string, data []byte) error {
+	status, body, err := b.do("POST", name, data)
+	if err != nil {
+		return err
+	}
+	if status != http.StatusOK && status != http.StatusCreated && status != http.StatusNoContent {
+		return responseError("post", name, status, body)
+	}
+
+	return nil
+}
+
+// Delete deletes an object
+func (b *Backend) Delete(name string) error {
+	status, body, err := b.do("DELETE", name, nil)
+	if err != nil {
+		return err
+	}
+	if status != http.StatusOK && status != http.StatusNoContent && status != http.StatusNotFound {
+		return responseError("delete", name, status, body)
+	}
+
+	return nil
+}
+
+// Lock locks the state
+func (b *Backend) Lock(info *client.LockInfo) error {
+	data, err := json.Marshal(info)
+	if err != nil {
+		return err
+	}
+
+	status, body, err := b.do("LOCK", client.StateName, data)
+	if err != nil {
+		return err
+	}
+
+	switch status {
+	case http.StatusOK:
+		return nil
+	case http.StatusLocked, http.StatusConflict:
+		holder := &client.LockInfo{}
+		if json.Unmarshal(body, holder) != nil {
+			holder = nil
+		}
+		return &client.LockedError{Holder: holder}
+	}
+
+	return responseError("lock", client.StateName, status, body)
+}
+
+// Unlock unlocks the state given the ID of the lock
+func (b *Backend) Unlock(id string) error {
+	data, err := json.Marshal(&client.LockInfo{ID: id})
+	if err != nil {
+		return err
+	}
+
+	status, body, err := b.do("UNLOCK", client.StateName, data)
+	if err != nil {
+		return err
+	}
+	if status != http.StatusOK {
+		return responseError("unlock", client.StateName, status, body)
+	}
+
+	return nil
+}
+
+// do sends a request on an object and returns the status code and the body of the response
+func (b *Backend) do(method string, name string, data []byte) (int, []byte, error) {
+	u := *b.address
+	u.Path += "/" + name
+
+	req, err := http.NewRequest(method, u.String(), bytes.NewReader(data))
+	if err != nil {
+		return 0, nil, err
+	}
+	if data != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if b.username != "" {
+		req.SetBasicAuth(b.username, b.password)
+	}
+
+	res, err := b.client.Do(req)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer res.Body.Close()
+
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	return res.StatusCode, body, nil
+}
+
+func responseError(action string, name string, status int, body []byte) error {
+	message := strings.TrimSpace(string(body))
+	if len(message) > 200 {
+		message = message[:200]
+	}
+	if message == "" {
+		message = http.StatusText(status)
+	}
+
+	return fmt.Errorf("Fail to %s `%s`: HTTP %d: %s", action, name, status, message)
+}
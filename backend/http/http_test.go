@@ -0,0 +1,113 @@
+package http
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/thbkrkr/ons/backend/backendtest"
+	"github.com/thbkrkr/ons/client"
+)
+
+// testServer is an HTTP backend server storing the objects in memory
+type testServer struct {
+	*httptest.Server
+
+	mu      sync.Mutex
+	objects map[string][]byte
+	lock    []byte
+}
+
+func newTestServer(t *testing.T) *testServer {
+	s := &testServer{objects: map[string][]byte{}}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+
+		username, password, ok := r.BasicAuth()
+		if !ok || username != "bim" || password != "bam" {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		if !strings.HasPrefix(r.URL.Path, "/states/") {
+			http.NotFound(w, r)
+			return
+		}
+		name := strings.TrimPrefix(r.URL.Path, "/states/")
+
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			t.Error(err)
+		}
+
+		switch r.Method {
+		case "GET":
+			data, ok := s.objects[name]
+			if !ok {
+				http.NotFound(w, r)
+				return
+			}
+			w.Write(data)
+		case "POST":
+			s.objects[name] = body
+			w.WriteHeader(http.StatusCreated)
+		case "DELETE":
+			delete(s.objects, name)
+			w.WriteHeader(http.StatusNoContent)
+		case "LOCK":
+			if s.lock != nil {
+				w.WriteHeader(http.StatusLocked)
+				w.Write(s.lock)
+				return
+			}
+			s.lock = body
+		case "UNLOCK":
+			if s.lock == nil {
+				http.Error(w, "State not locked", http.StatusConflict)
+				return
+			}
+			var holder, info client.LockInfo
+			json.Unmarshal(s.lock, &holder)
+			json.Unmarshal(body, &info)
+			if info.ID != holder.ID {
+				http.Error(w, "Lock ID `"+info.ID+"` does not match the lock ID `"+holder.ID+"`", http.StatusConflict)
+				return
+			}
+			s.lock = nil
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	}))
+	return s
+}
+
+func TestBackend(t *testing.T) {
+	s := newTestServer(t)
+	defer s.Close()
+
+	b, err := NewBackend(s.URL+"/states/", "bim", "bam")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	backendtest.Run(t, b)
+}
+
+func TestUnauthorized(t *testing.T) {
+	s := newTestServer(t)
+	defer s.Close()
+
+	b, err := NewBackend(s.URL+"/states", "bim", "boum")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = b.Get(client.StateName)
+	if err == nil || !strings.Contains(err.Error(), "HTTP 401: Unauthorized") {
+		t.Errorf("expected an unauthorized error, got %v", err)
+	}
+}
@@ -0,0 +1,300 @@
+// Package s3 implements an ons state backend storing the state in a bucket
+// of an S3 compatible object store (AWS S3, MinIO, Ceph, ...).
+package s3
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/thbkrkr/ons/client"
+)
+
+// DefaultTimeout is the timeout of the requests to the object store
+const DefaultTimeout = 30 * time.Second
+
+// lockName is the name of the object locking the state
+const lockName = "ons.lock"
+
+// Backend stores the state in a bucket. The state is locked by a lock object
+// created only if it does not exist (conditional write with If-None-Match).
+// Requests are authenticated with AWS Signature Version 4.
+type Backend struct {
+	endpoint     *url.URL
+	bucket       string
+	prefix       string
+	region       string
+	accessKey    string
+	secretKey    string
+	sessionToken string
+	client       *http.Client
+}
+
+// NewBackend creates a new S3 backend given the endpoint of the object store
+// (https://s3.eu-west-1.amazonaws.com, http://localhost:9000, ...), a bucket,
+// a prefix of the keys of the objects and credentials. Objects are addressed
+// with path-style URLs.
+func NewBackend(endpoint string, bucket string, prefix string, region string, accessKey string, secretKey string) (*Backend, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("Invalid S3 endpoint `%s`", endpoint)
+	}
+	if bucket == "" {
+		return nil, fmt.Errorf("S3 bucket not set")
+	}
+
+	return &Backend{
+		endpoint:  u,
+		bucket:    bucket,
+		prefix:    strings.Trim(prefix, "/"),
+		region:    region,
+		accessKey: accessKey,
+		secretKey: secretKey,
+		client:    &http.Client{Timeout: DefaultTimeout},
+	}, nil
+}
+
+// SetSessionToken sets the session token of temporary credentials
+func (b *Backend) SetSessionToken(token string) {
+	b.sessionToken = token
+}
+
+// Get gets an object
+func (b *Backend) Get(name string) ([]byte, error) {
+	status, body, err := b.do("GET", name, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	switch status {
+	case http.StatusOK:
+		return body, nil
+	case http.StatusNotFound:
+		return nil, nil
+	}
+
+	return nil, responseError("get", name, status, body)
+}
+
+// Put creates or replaces an object
+func (b *Backend) Put(name string, data []byte) error {
+	status, body, err := b.do("PUT", name, data, nil)
+	if err != nil {
+		return err
+	}
+	if status != http.StatusOK {
+		return responseError("put", name, status, body)
+	}
+
+	return nil
+}
+
+// Delete deletes an object
+func (b *Backend) Delete(name string) error {
+	status, body, err := b.do("DELETE", name, nil, nil)
+	if err != nil {
+		return err
+	}
+	if status != http.StatusNoContent && status != http.StatusOK && status != http.StatusNotFound {
+		return responseError("delete", name, status, body)
+	}
+
+	return nil
+}
+
+// Lock creates the lock object if it does not exist
+func (b *Backend) Lock(info *client.LockInfo) error {
+	data, err := json.Marshal(info)
+	if err != nil {
+		return err
+	}
+
+	status, body, err := b.do("PUT", lockName, data, map[string]string{"If-None-Match": "*"})
+	if err != nil {
+		return err
+	}
+
+	switch status {
+	case http.StatusOK:
+		return nil
+	case http.StatusPreconditionFailed, http.StatusConflict:
+		holder, locked, err := b.holder()
+		if err != nil {
+			return err
+		}
+		return &client.LockedError{Holder: holder, Invalid: locked && holder == nil}
+	}
+
+	return responseError("lock", lockName, status, body)
+}
+
+// Unlock deletes the lock object if its ID matches. An invalid lock object
+// is deleted whatever the ID.
+func (b *Backend) Unlock(id string) error {
+	holder, locked, err := b.holder()
+	if err != nil {
+		return err
+	}
+	if !locked {
+		return fmt.Errorf("State not locked")
+	}
+	if holder != nil && holder.ID != id {
+		return fmt.Errorf("Lock ID `%s` does not match the lock ID `%s`", id, holder.ID)
+	}
+
+	return b.Delete(lockName)
+}
+
+// holder gets the lock object and returns whether the state is locked.
+// The holder is nil if the lock object can not be decoded.
+func (b *Backend) holder() (*client.LockInfo, bool, error) {
+	data, err := b.Get(lockName)
+	if err != nil || data == nil {
+		return nil, false, err
+	}
+
+	holder := &client.LockInfo{}
+	if json.Unmarshal(data, holder) != nil {
+		return nil, true, nil
+	}
+
+	return holder, true, nil
+}
+
+// do sends a signed request on an object and returns the status code and the body of the response
+func (b *Backend) do(method string, name string, data []byte, headers map[string]string) (int, []byte, error) {
+	key := name
+	if b.prefix != "" {
+		key = path.Join(b.prefix, name)
+	}
+
+	u := *b.endpoint
+	u.Path = strings.TrimSuffix(u.Path, "/") + "/" + b.bucket + "/" + key
+	u.RawPath = uriEncode(u.Path)
+
+	req, err := http.NewRequest(method, u.String(), bytes.NewReader(data))
+	if err != nil {
+		return 0, nil, err
+	}
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+
+	b.sign(req, data, time.Now().UTC())
+
+	res, err := b.client.Do(req)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer res.Body.Close()
+
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	return res.StatusCode, body, nil
+}
+
+// sign signs a request with AWS Signature Version 4
+func (b *Backend) sign(req *http.Request, payload []byte, now time.Time) {
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+	payloadHash := sha256Hex(payload)
+
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	if b.sessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", b.sessionToken)
+	}
+
+	signedHeaders := []string{"host", "x-amz-content-sha256", "x-amz-date"}
+	if b.sessionToken != "" {
+		signedHeaders = append(signedHeaders, "x-amz-security-token")
+	}
+
+	canonicalHeaders := ""
+	for _, h := range signedHeaders {
+		value := req.Header.Get(h)
+		if h == "host" {
+			value = req.URL.Host
+		}
+		canonicalHeaders += h + ":" + strings.TrimSpace(value) + "\n"
+	}
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.RawQuery,
+		canonicalHeaders,
+		strings.Join(signedHeaders, ";"),
+		payloadHash,
+	}, "\n")
+
+	scope := date + "/" + b.region + "/s3/aws4_request"
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + sha256Hex([]byte(canonicalRequest))
+
+	key := hmacSHA256([]byte("AWS4"+b.secretKey), date)
+	key = hmacSHA256(key, b.region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		b.accessKey, scope, strings.Join(signedHeaders, ";"), signature))
+}
+
+// uriEncode encodes a path as required by AWS Signature Version 4:
+// every byte except the unreserved characters and the slashes is percent-encoded
+func uriEncode(p string) string {
+	var buf bytes.Buffer
+	for i := 0; i < len(p); i++ {
+		c := p[i]
+		if (c >= 'A' && c <= 'Z') || (c >= 'a' && c <= 'z') || (c >= '0' && c <= '9') ||
+			c == '-' || c == '_' || c == '.' || c == '~' || c == '/' {
+			buf.WriteByte(c)
+		} else {
+			fmt.Fprintf(&buf, "%%%02X", c)
+		}
+	}
+	return buf.String()
+}
+
+func sha256Hex(data []byte) string {
+	h := sha256.Sum256(data)
+	return hex.EncodeToString(h[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+// s3Error represents an error returned by the object store
+type s3Error struct {
+	Code    string `xml:"Code"`
+	Message string `xml:"Message"`
+}
+
+func responseError(action string, name string, status int, body []byte) error {
+	var e s3Error
+	if xml.Unmarshal(body, &e) == nil && e.Code != "" {
+		return fmt.Errorf("Fail to %s S3 object `%s`: %s: %s", action, name, e.Code, e.Message)
+	}
+
+	return fmt.Errorf("Fail to %s S3 object `%s`: HTTP %d", action, name, status)
+}
@@ -0,0 +1,237 @@
+package s3
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/thbkrkr/ons/backend/backendtest"
+	"github.com/thbkrkr/ons/client"
+)
+
+const (
+	testAccessKey = "AKIDBIMBAM"
+	testSecretKey = "bim/bam/boum"
+	testRegion    = "eu-west-1"
+)
+
+// testServer is an S3 compatible object store storing the objects in memory.
+// It verifies the AWS Signature Version 4 of the requests and supports the
+// conditional writes with If-None-Match.
+type testServer struct {
+	*httptest.Server
+
+	mu      sync.Mutex
+	objects map[string][]byte
+	token   string
+}
+
+func newTestServer(t *testing.T) *testServer {
+	s := &testServer{objects: map[string][]byte{}}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			t.Error(err)
+		}
+
+		if code, message := s.verify(r, body); code != "" {
+			writeError(w, http.StatusForbidden, code, message)
+			return
+		}
+
+		key := r.URL.Path
+		switch r.Method {
+		case "GET":
+			data, ok := s.objects[key]
+			if !ok {
+				writeError(w, http.StatusNotFound, "NoSuchKey", "The specified key does not exist.")
+				return
+			}
+			w.Write(data)
+		case "PUT":
+			if _, ok := s.objects[key]; ok && r.Header.Get("If-None-Match") == "*" {
+				writeError(w, http.StatusPreconditionFailed, "PreconditionFailed",
+					"At least one of the pre-conditions you specified did not hold")
+				return
+			}
+			s.objects[key] = body
+		case "DELETE":
+			delete(s.objects, key)
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			writeError(w, http.StatusMethodNotAllowed, "MethodNotAllowed", "The specified method is not allowed")
+		}
+	}))
+	return s
+}
+
+// verify checks the signature of a request and returns the code and the message of the error if it is invalid
+func (s *testServer) verify(r *http.Request, body []byte) (string, string) {
+	auth := r.Header.Get("Authorization")
+	if !strings.HasPrefix(auth, "AWS4-HMAC-SHA256 ") {
+		return "AccessDenied", "Missing AWS4-HMAC-SHA256 authorization"
+	}
+	params := map[string]string{}
+	for _, p := range strings.Split(strings.TrimPrefix(auth, "AWS4-HMAC-SHA256 "), ", ") {
+		kv := strings.SplitN(p, "=", 2)
+		if len(kv) != 2 {
+			return "AuthorizationHeaderMalformed", "Invalid authorization parameter " + p
+		}
+		params[kv[0]] = kv[1]
+	}
+
+	credential := strings.Split(params["Credential"], "/")
+	if len(credential) != 5 || credential[2] != testRegion || credential[3] != "s3" || credential[4] != "aws4_request" {
+		return "AuthorizationHeaderMalformed", "Invalid credential " + params["Credential"]
+	}
+	if credential[0] != testAccessKey {
+		return "InvalidAccessKeyId", "The AWS Access Key Id you provided does not exist in our records."
+	}
+
+	amzDate := r.Header.Get("X-Amz-Date")
+	if !strings.HasPrefix(amzDate, credential[1]) {
+		return "AuthorizationHeaderMalformed", "The date of the credential does not match X-Amz-Date"
+	}
+	payloadHash := sha256.Sum256(body)
+	if r.Header.Get("X-Amz-Content-Sha256") != hex.EncodeToString(payloadHash[:]) {
+		return "XAmzContentSHA256Mismatch", "The provided x-amz-content-sha256 header does not match what was computed."
+	}
+	if s.token != "" && r.Header.Get("X-Amz-Security-Token") != s.token {
+		return "InvalidToken", "The provided token is malformed or otherwise invalid."
+	}
+
+	signed := map[string]bool{}
+	canonicalHeaders := ""
+	for _, h := range strings.Split(params["SignedHeaders"], ";") {
+		signed[h] = true
+		value := r.Header.Get(h)
+		if h == "host" {
+			value = r.Host
+		}
+		canonicalHeaders += h + ":" + value + "\n"
+	}
+	for _, h := range []string{"host", "x-amz-content-sha256", "x-amz-date"} {
+		if !signed[h] {
+			return "AccessDenied", "Header " + h + " not signed"
+		}
+	}
+	if s.token != "" && !signed["x-amz-security-token"] {
+		return "AccessDenied", "Header x-amz-security-token not signed"
+	}
+
+	canonicalRequest := r.Method + "\n" + r.URL.EscapedPath() + "\n" + r.URL.RawQuery + "\n" +
+		canonicalHeaders + "\n" + params["SignedHeaders"] + "\n" + r.Header.Get("X-Amz-Content-Sha256")
+	canonicalHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + strings.Join(credential[1:], "/") + "\n" +
+		hex.EncodeToString(canonicalHash[:])
+
+	key := []byte("AWS4" + testSecretKey)
+	for _, data := range []string{credential[1], testRegion, "s3", "aws4_request", stringToSign} {
+		h := hmac.New(sha256.New, key)
+		h.Write([]byte(data))
+		key = h.Sum(nil)
+	}
+	if params["Signature"] != hex.EncodeToString(key) {
+		return "SignatureDoesNotMatch", "The request signature we calculated does not match the signature you provided."
+	}
+
+	return "", ""
+}
+
+func writeError(w http.ResponseWriter, status int, code string, message string) {
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(status)
+	fmt.Fprintf(w, "<?xml version=\"1.0\" encoding=\"UTF-8\"?>\n<Error><Code>%s</Code><Message>%s</Message></Error>", code, message)
+}
+
+func TestBackend(t *testing.T) {
+	s := newTestServer(t)
+	defer s.Close()
+
+	b, err := NewBackend(s.URL, "ons", "/bada.boum/", testRegion, testAccessKey, testSecretKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	backendtest.Run(t, b)
+	backendtest.RunInvalidLock(t, b, lockName)
+}
+
+func TestKeys(t *testing.T) {
+	s := newTestServer(t)
+	defer s.Close()
+
+	b, err := NewBackend(s.URL, "ons", "bada.boum", testRegion, testAccessKey, testSecretKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range []string{client.StateName, "snapshots/bim bam+boum=1.json"} {
+		err = b.Put(name, []byte("{}"))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err = b.Lock(&client.LockInfo{ID: "1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, key := range []string{"/ons/bada.boum/ons.state.json", "/ons/bada.boum/snapshots/bim bam+boum=1.json", "/ons/bada.boum/ons.lock"} {
+		if s.objects[key] == nil {
+			t.Errorf("expected object %s, got %v", key, s.objects)
+		}
+	}
+}
+
+func TestSessionToken(t *testing.T) {
+	s := newTestServer(t)
+	s.token = "bim-bam-boum"
+	defer s.Close()
+
+	b, err := NewBackend(s.URL, "ons", "", testRegion, testAccessKey, testSecretKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = b.Put(client.StateName, []byte("{}"))
+	if err == nil || !strings.Contains(err.Error(), "InvalidToken") {
+		t.Errorf("expected an invalid token error, got %v", err)
+	}
+
+	b.SetSessionToken(s.token)
+	err = b.Put(client.StateName, []byte("{}"))
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestInvalidSignature(t *testing.T) {
+	s := newTestServer(t)
+	defer s.Close()
+
+	b, err := NewBackend(s.URL, "ons", "", testRegion, testAccessKey, "bim/bam/bada")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = b.Get(client.StateName)
+	if err == nil || !strings.Contains(err.Error(), "SignatureDoesNotMatch") {
+		t.Errorf("expected a signature error, got %v", err)
+	}
+
+	err = b.Lock(&client.LockInfo{ID: "1"})
+	if err == nil || !strings.Contains(err.Error(), "Fail to lock S3 object `ons.lock`: SignatureDoesNotMatch") {
+		t.Errorf("expected a signature error, got %v", err)
+	}
+}
@@ -0,0 +1,174 @@
+package client
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// StateName is the name of the state in a state backend
+const StateName = "ons.state.json"
+
+// StateBackend stores the state and locks it
+type StateBackend interface {
+	// Get gets an object given its name. It returns nil if the object does not exist.
+	Get(name string) ([]byte, error)
+	// Put creates or replaces an object
+	Put(name string, data []byte) error
+	// Delete deletes an object if it exists
+	Delete(name string) error
+	// Lock locks the state. It fails with a *LockedError if the state is already locked.
+	Lock(info *LockInfo) error
+	// Unlock unlocks the state given the ID of the lock
+	Unlock(id string) error
+}
+
+// LockedError is the error returned when locking a locked state. Invalid is
+// set if the lock exists but its holder can not be decoded.
+type LockedError struct {
+	Holder  *LockInfo
+	Invalid bool
+}
+
+func (e *LockedError) Error() string {
+	if e.Invalid {
+		return "State locked by an invalid lock. " +
+			"If no ons command is running, remove the lock with `ons force-unlock invalid`"
+	}
+
+	h := e.Holder
+	if h == nil || h.ID == "" {
+		return "State already locked"
+	}
+
+	return fmt.Sprintf("State locked by %s (pid %d on %s) running `%s` since %s, lock ID %s. "+
+		"If no ons command is running, remove the lock with `ons force-unlock %s`",
+		h.Who, h.PID, h.Host, h.Operation, h.Created.Format(time.RFC3339), h.ID, h.ID)
+}
+
+// stale returns true if the lock can be taken over: it is invalid or held
+// by a process that is no longer running on this host
+func (e *LockedError) stale() bool {
+	return e.Invalid || (e.Holder != nil && e.Holder.stale())
+}
+
+// LocalBackend stores the state in a directory and locks it with a lock file
+type LocalBackend struct {
+	dir string
+}
+
+// NewLocalBackend creates a new local backend given a directory
+func NewLocalBackend(dir string) *LocalBackend {
+	return &LocalBackend{dir: dir}
+}
+
+// Get reads a file of the directory
+func (b *LocalBackend) Get(name string) ([]byte, error) {
+	data, err := ioutil.ReadFile(filepath.Join(b.dir, name))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return data, nil
+}
+
+// Put writes a file of the directory atomically
+func (b *LocalBackend) Put(name string, data []byte) error {
+	path := filepath.Join(b.dir, name)
+
+	err := os.MkdirAll(filepath.Dir(path), 0755)
+	if err != nil {
+		return err
+	}
+
+	return writeFile(path, data, 0644)
+}
+
+// Delete removes a file of the directory
+func (b *LocalBackend) Delete(name string) error {
+	err := os.Remove(filepath.Join(b.dir, name))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	return nil
+}
+
+// Lock creates the lock file ons.lock. The lock information is written in a
+// temporary file linked to ons.lock, so that the lock never exists half-written.
+func (b *LocalBackend) Lock(info *LockInfo) error {
+	data, err := info.encode()
+	if err != nil {
+		return err
+	}
+
+	tmp, err := ioutil.TempFile(b.dir, ".ons.lock.*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	_, err = tmp.Write(data)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+
+	err = os.Link(tmp.Name(), b.lockPath())
+	if os.IsExist(err) {
+		holder, locked, err := b.holder()
+		if err != nil {
+			return err
+		}
+		return &LockedError{Holder: holder, Invalid: locked && holder == nil}
+	}
+
+	return err
+}
+
+// Unlock removes the lock file if its ID matches. An invalid lock file is
+// removed whatever the ID.
+func (b *LocalBackend) Unlock(id string) error {
+	holder, locked, err := b.holder()
+	if err != nil {
+		return err
+	}
+	if !locked {
+		return fmt.Errorf("State not locked")
+	}
+	if holder != nil && holder.ID != id {
+		return fmt.Errorf("Lock ID `%s` does not match the lock ID `%s`", id, holder.ID)
+	}
+
+	return os.Remove(b.lockPath())
+}
+
+func (b *LocalBackend) lockPath() string {
+	return filepath.Join(b.dir, "ons.lock")
+}
+
+// holder reads the lock file and returns whether the state is locked.
+// The holder is nil if the lock file can not be decoded.
+func (b *LocalBackend) holder() (*LockInfo, bool, error) {
+	data, err := b.Get("ons.lock")
+	if err != nil || data == nil {
+		return nil, false, err
+	}
+
+	holder, err := decodeLockInfo(data)
+	if err != nil {
+		return nil, true, nil
+	}
+
+	return holder, true, nil
+}
@@ -0,0 +1,347 @@
+package client
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// DefaultZoneFileTTL is the default $TTL of the exported zone files. The records
+// without TTL of an imported zone file with this $TTL are imported without TTL.
+const DefaultZoneFileTTL = 3600
+
+// WriteZoneFile writes records of a zone in the RFC 1035 zone file format
+func WriteZoneFile(w io.Writer, zone string, ttl int, records Records) error {
+	_, err := fmt.Fprintf(w, "$ORIGIN %s.\n$TTL %d\n\n", zone, ttl)
+	if err != nil {
+		return err
+	}
+
+	for _, r := range records {
+		name := r.SubDomain
+		if name == "" {
+			name = "@"
+		}
+
+		recordTTL := ""
+		if r.TTL != 0 {
+			recordTTL = strconv.Itoa(r.TTL)
+		}
+
+		target := r.Target
+		if r.Type() == "TXT" && !strings.HasPrefix(target, `"`) {
+			target = strconv.Quote(target)
+		}
+
+		_, err := fmt.Fprintf(w, "%-24s %6s IN %-5s %s\n", name, recordTTL, r.Type(), target)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// ParseZoneFile parses records of a zone in the RFC 1035 zone file format.
+// If the zone is empty, the zone is the first $ORIGIN of the file.
+// SOA records are ignored.
+func ParseZoneFile(r io.Reader, zone string) (Records, error) {
+	p := &zoneParser{
+		zone:   strings.TrimSuffix(zone, "."),
+		origin: strings.TrimSuffix(zone, "."),
+	}
+
+	scanner := bufio.NewScanner(r)
+	lineNumber := 0
+	var tokens []string
+	blankOwner := false
+	openParens := 0
+	startLine := 0
+
+	for scanner.Scan() {
+		lineNumber++
+		line := scanner.Text()
+
+		lineTokens, parens, err := tokenize(line)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %s", lineNumber, err)
+		}
+
+		if openParens == 0 {
+			if len(lineTokens) == 0 {
+				continue
+			}
+			startLine = lineNumber
+			blankOwner = len(line) > 0 && unicode.IsSpace(rune(line[0]))
+			tokens = nil
+		}
+
+		tokens = append(tokens, lineTokens...)
+		openParens += parens
+		if openParens < 0 {
+			return nil, fmt.Errorf("line %d: unbalanced parentheses", lineNumber)
+		}
+		if openParens > 0 {
+			continue
+		}
+
+		err = p.parseEntry(tokens, blankOwner)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %s", startLine, err)
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	if openParens > 0 {
+		return nil, fmt.Errorf("line %d: unbalanced parentheses", startLine)
+	}
+
+	return p.records, nil
+}
+
+// LoadZoneFile parses records of a zone from a file in the RFC 1035 zone file format
+func LoadZoneFile(filepath string, zone string) (Records, error) {
+	file, err := os.Open(filepath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	records, err := ParseZoneFile(file, zone)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", filepath, err)
+	}
+
+	return records, nil
+}
+
+// zoneParser holds the state of the parsing of a zone file
+type zoneParser struct {
+	zone       string
+	origin     string
+	owner      string
+	defaultTTL int
+	records    Records
+}
+
+func (p *zoneParser) parseEntry(tokens []string, blankOwner bool) error {
+	switch strings.ToUpper(tokens[0]) {
+	case "$ORIGIN":
+		if len(tokens) != 2 {
+			return fmt.Errorf("$ORIGIN requires a domain name")
+		}
+		p.origin = p.absolute(tokens[1])
+		if p.zone == "" {
+			p.zone = p.origin
+		}
+		return nil
+	case "$TTL":
+		if len(tokens) != 2 {
+			return fmt.Errorf("$TTL requires a TTL")
+		}
+		ttl, err := parseTTL(tokens[1])
+		if err != nil {
+			return err
+		}
+		// The records without TTL keep the default TTL of the zone (TTL 0)
+		// if $TTL is the default $TTL of the exported zone files
+		p.defaultTTL = ttl
+		if ttl == DefaultZoneFileTTL {
+			p.defaultTTL = 0
+		}
+		return nil
+	case "$INCLUDE", "$GENERATE":
+		return fmt.Errorf("%s is not supported", tokens[0])
+	}
+
+	if p.zone == "" {
+		return fmt.Errorf("no zone, set the zone or $ORIGIN")
+	}
+
+	if !blankOwner {
+		p.owner = p.absolute(tokens[0])
+		tokens = tokens[1:]
+	}
+	if p.owner == "" {
+		return fmt.Errorf("no owner name")
+	}
+
+	// [TTL] [class] type rdata or [class] [TTL] type rdata
+	ttl := p.defaultTTL
+	for i := 0; i < 2 && len(tokens) > 0; i++ {
+		if strings.ToUpper(tokens[0]) == "IN" {
+			tokens = tokens[1:]
+		} else if t, err := parseTTL(tokens[0]); err == nil {
+			ttl = t
+			tokens = tokens[1:]
+		}
+	}
+
+	if len(tokens) < 2 {
+		return fmt.Errorf("record requires a type and data")
+	}
+
+	fieldType := strings.ToUpper(tokens[0])
+	rdata := tokens[1:]
+
+	if fieldType == "SOA" {
+		return nil
+	}
+	if !IsSupportedFieldType(fieldType) {
+		return fmt.Errorf("record type `%s` not supported", tokens[0])
+	}
+
+	subDomain, err := p.subDomain(p.owner)
+	if err != nil {
+		return err
+	}
+
+	// Domain names of the record data are fully qualified
+	switch fieldType {
+	case "CNAME", "NS":
+		rdata[0] = p.absolute(rdata[0]) + "."
+	case "MX":
+		if len(rdata) != 2 {
+			return fmt.Errorf("MX record requires a preference and an exchange")
+		}
+		rdata[1] = p.absolute(rdata[1]) + "."
+	case "SRV":
+		if len(rdata) != 4 {
+			return fmt.Errorf("SRV record requires a priority, a weight, a port and a target")
+		}
+		rdata[3] = p.absolute(rdata[3]) + "."
+	}
+
+	p.records = append(p.records, Record{
+		Zone:      p.zone,
+		FieldType: fieldType,
+		SubDomain: subDomain,
+		Target:    strings.Join(rdata, " "),
+		TTL:       ttl,
+	})
+
+	return nil
+}
+
+// absolute returns the fully qualified name, without the trailing dot,
+// of a name relative to the current origin
+func (p *zoneParser) absolute(name string) string {
+	if name == "@" {
+		return p.origin
+	}
+	if strings.HasSuffix(name, ".") {
+		return strings.TrimSuffix(name, ".")
+	}
+	if p.origin == "" {
+		return name
+	}
+	return name + "." + p.origin
+}
+
+// subDomain returns the sub domain of a fully qualified name in the zone
+func (p *zoneParser) subDomain(name string) (string, error) {
+	if strings.EqualFold(name, p.zone) {
+		return "", nil
+	}
+
+	suffix := "." + p.zone
+	if len(name) > len(suffix) && strings.EqualFold(name[len(name)-len(suffix):], suffix) {
+		return name[:len(name)-len(suffix)], nil
+	}
+
+	return "", fmt.Errorf("`%s` is outside of the zone `%s`", name, p.zone)
+}
+
+// tokenize splits a zone file line into tokens, removing comments and keeping
+// quoted strings in one token. It returns the balance of parentheses.
+func tokenize(line string) ([]string, int, error) {
+	var tokens []string
+	parens := 0
+	token := ""
+	inQuotes := false
+
+	for i := 0; i < len(line); i++ {
+		c := line[i]
+
+		if inQuotes {
+			token += string(c)
+			if c == '\\' && i+1 < len(line) {
+				i++
+				token += string(line[i])
+			} else if c == '"' {
+				inQuotes = false
+			}
+			continue
+		}
+
+		switch {
+		case c == ';':
+			i = len(line)
+		case c == '"':
+			inQuotes = true
+			token += string(c)
+		case c == '(' || c == ')':
+			if c == '(' {
+				parens++
+			} else {
+				parens--
+			}
+			fallthrough
+		case c == ' ' || c == '\t':
+			if token != "" {
+				tokens = append(tokens, token)
+				token = ""
+			}
+		default:
+			token += string(c)
+		}
+	}
+
+	if inQuotes {
+		return nil, 0, fmt.Errorf("unterminated quoted string")
+	}
+	if token != "" {
+		tokens = append(tokens, token)
+	}
+
+	return tokens, parens, nil
+}
+
+// parseTTL parses a TTL in seconds or with BIND units (1w2d3h4m5s)
+func parseTTL(value string) (int, error) {
+	if ttl, err := strconv.Atoi(value); err == nil && ttl >= 0 {
+		return ttl, nil
+	}
+
+	units := map[byte]int{'s': 1, 'm': 60, 'h': 3600, 'd': 86400, 'w': 604800}
+
+	ttl := 0
+	number := ""
+	for i := 0; i < len(value); i++ {
+		c := value[i]
+		if c >= '0' && c <= '9' {
+			number += string(c)
+			continue
+		}
+		unit, ok := units[byte(unicode.ToLower(rune(c)))]
+		if !ok || number == "" {
+			return 0, fmt.Errorf("invalid TTL `%s`", value)
+		}
+		n, _ := strconv.Atoi(number)
+		ttl += n * unit
+		number = ""
+	}
+
+	if number != "" || ttl == 0 {
+		return 0, fmt.Errorf("invalid TTL `%s`", value)
+	}
+
+	return ttl, nil
+}
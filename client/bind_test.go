@@ -0,0 +1,71 @@
+package client
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestZoneFileRoundTrip(t *testing.T) {
+	records := Records{
+		{Zone: "bada.boum", SubDomain: "", FieldType: "MX", Target: "10 mx.bada.boum."},
+		{Zone: "bada.boum", SubDomain: "bim", FieldType: "A", Target: "1.2.3.4"},
+		{Zone: "bada.boum", SubDomain: "bam", FieldType: "A", Target: "1.2.3.5", TTL: 300},
+		{Zone: "bada.boum", SubDomain: "www", FieldType: "CNAME", Target: "bim.bada.boum."},
+		{Zone: "bada.boum", SubDomain: "txt", FieldType: "TXT", Target: `"v=spf1 -all"`},
+	}
+
+	var buf bytes.Buffer
+	err := WriteZoneFile(&buf, "bada.boum", DefaultZoneFileTTL, records)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	parsed, err := ParseZoneFile(&buf, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(parsed, records) {
+		t.Errorf("expected %+v, got %+v", records, parsed)
+	}
+}
+
+func TestParseZoneFileTTL(t *testing.T) {
+	for _, test := range []struct {
+		zoneFile string
+		expected []int
+	}{
+		{`$ORIGIN bada.boum.
+$TTL 300
+bim         IN A 1.2.3.4
+bam    3600 IN A 1.2.3.5
+boum   1h      A 1.2.3.6
+`, []int{300, 3600, 3600}},
+		{`$ORIGIN bada.boum.
+$TTL 3600
+bim         IN A 1.2.3.4
+bam     300 IN A 1.2.3.5
+$TTL 1d
+boum       IN A 1.2.3.6
+`, []int{0, 300, 86400}},
+		{`$ORIGIN bada.boum.
+bim         IN A 1.2.3.4
+`, []int{0}},
+	} {
+		records, err := ParseZoneFile(strings.NewReader(test.zoneFile), "")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(records) != len(test.expected) {
+			t.Fatalf("expected %d records, got %+v", len(test.expected), records)
+		}
+		for i, r := range records {
+			if r.TTL != test.expected[i] {
+				t.Errorf("%s: expected TTL %d, got %d", r.SubDomain, test.expected[i], r.TTL)
+			}
+		}
+	}
+}
@@ -0,0 +1,157 @@
+package client
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"time"
+)
+
+// DefaultCacheTTL is the duration during which the cached records of a zone are used
+const DefaultCacheTTL = 5 * time.Minute
+
+// zoneCache represents the cached records of a zone keyed by record ID
+type zoneCache struct {
+	ListedAt time.Time        `json:"listedAt"`
+	Records  map[int64]Record `json:"records"`
+}
+
+// recordCache is an on-disk cache of the records of the DNS zones
+type recordCache struct {
+	cachePath string
+	ttl       time.Duration
+	zones     map[string]*zoneCache
+}
+
+// loadCache loads the cache. A missing or unreadable cache is an empty cache.
+func loadCache(cachePath string, ttl time.Duration) (*recordCache, error) {
+	cache := &recordCache{
+		cachePath: cachePath,
+		ttl:       ttl,
+		zones:     map[string]*zoneCache{},
+	}
+
+	data, err := ioutil.ReadFile(cachePath)
+	if os.IsNotExist(err) {
+		return cache, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(data, &cache.zones); err != nil {
+		cache.zones = map[string]*zoneCache{}
+	}
+
+	return cache, nil
+}
+
+func (c *recordCache) save() error {
+	data, err := json.MarshalIndent(c.zones, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return writeFile(c.cachePath, data, 0644)
+}
+
+// get returns the cached records of a zone. If fresh is true, the records
+// are only returned if they have been cached for less than the TTL.
+func (c *recordCache) get(zone string, fresh bool) (Records, bool) {
+	z, ok := c.zones[zone]
+	if !ok || (fresh && time.Since(z.ListedAt) > c.ttl) {
+		return nil, false
+	}
+
+	records := Records{}
+	for _, r := range z.Records {
+		records = append(records, r)
+	}
+
+	return records, true
+}
+
+// set caches the records of a zone
+func (c *recordCache) set(zone string, records Records) error {
+	z := &zoneCache{
+		ListedAt: time.Now(),
+		Records:  map[int64]Record{},
+	}
+	for _, r := range records {
+		z.Records[r.ID] = r
+	}
+
+	c.zones[zone] = z
+
+	return c.save()
+}
+
+// invalidate removes the cached records of a zone. The whole cache
+// is removed if it cannot be saved without them.
+func (c *recordCache) invalidate(zone string) {
+	if _, ok := c.zones[zone]; !ok {
+		return
+	}
+
+	delete(c.zones, zone)
+
+	if err := c.save(); err != nil {
+		c.zones = map[string]*zoneCache{}
+		os.Remove(c.cachePath)
+	}
+}
+
+// cachedProvider is a provider caching the records of the DNS zones.
+// The records of a zone are invalidated when the zone is modified.
+type cachedProvider struct {
+	Provider
+	cache *recordCache
+}
+
+func (p cachedProvider) List(zone string) (Records, error) {
+	if records, ok := p.cache.get(zone, true); ok {
+		return records, nil
+	}
+
+	records, err := p.Provider.List(zone)
+	if err != nil {
+		return nil, err
+	}
+
+	err = p.cache.set(zone, records)
+	if err != nil {
+		return nil, err
+	}
+
+	return records, nil
+}
+
+func (p cachedProvider) Get(zone string, id int64) (*Record, error) {
+	if z, ok := p.cache.zones[zone]; ok && time.Since(z.ListedAt) <= p.cache.ttl {
+		if r, ok := z.Records[id]; ok {
+			return &r, nil
+		}
+	}
+
+	return p.Provider.Get(zone, id)
+}
+
+func (p cachedProvider) Create(zone string, record Record) (*Record, error) {
+	defer p.cache.invalidate(zone)
+	return p.Provider.Create(zone, record)
+}
+
+func (p cachedProvider) Update(zone string, record Record) error {
+	defer p.cache.invalidate(zone)
+	return p.Provider.Update(zone, record)
+}
+
+func (p cachedProvider) Delete(zone string, id int64) error {
+	defer p.cache.invalidate(zone)
+	return p.Provider.Delete(zone, id)
+}
+
+func (p cachedProvider) Refresh(zone string) error {
+	defer p.cache.invalidate(zone)
+	return p.Provider.Refresh(zone)
+}
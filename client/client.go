@@ -2,9 +2,12 @@ package client
 
 import (
 	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
 
 	"github.com/fatih/color"
-	"github.com/ovh/go-ovh/ovh"
 )
 
 var (
@@ -13,54 +16,104 @@ var (
 	cyan    = color.New(color.FgCyan).PrintfFunc()
 )
 
-// OnsClient is a wrapper of an OVH API Client, a state and a config
+// OnsClient is a wrapper of a DNS provider, a state and a config
 type OnsClient struct {
-	client     *ovh.Client
+	provider   Provider
 	config     *DNSConfig
 	configPath string
 	state      *DNSState
-	statePath  string
+	cache      *recordCache
+	refresh    bool
+	live       bool
+	defaultTTL int
+	normalizer TargetNormalizer
 }
 
-// NewOnsClient creates a new ONS client
-func NewOnsClient(statePath string, configPath string,
-	endpoint string, ak string, as string, ck string) (*OnsClient, error) {
-
-	ovhClient, err := ovh.NewClient(endpoint, ak, as, ck)
+// NewOnsClient creates a new ONS client given a DNS provider and a state backend.
+// The default zone is the zone of the configured records that do not define one.
+// The variables are the variables of a templated config.
+func NewOnsClient(provider Provider, backend StateBackend, configPath string, defaultZone string, vars Vars) (*OnsClient, error) {
+	config, err := loadConfig(configPath, defaultZone, vars)
 	if err != nil {
 		return nil, err
 	}
 
-	config, err := loadConfig(configPath)
+	state, err := loadState(backend)
 	if err != nil {
 		return nil, err
 	}
 
-	state, err := loadState(statePath)
-	if err != nil {
-		return nil, err
+	defaultTTL := 0
+	if p, ok := provider.(DefaultTTLProvider); ok {
+		defaultTTL = p.DefaultTTL()
 	}
+	normalizer, _ := provider.(TargetNormalizer)
 
 	return &OnsClient{
-		client:     ovhClient,
+		provider:   provider,
 		configPath: configPath,
 		config:     config,
-		statePath:  statePath,
 		state:      state,
+		refresh:    true,
+		defaultTTL: defaultTTL,
+		normalizer: normalizer,
 	}, nil
 }
 
+// EnableCache caches the records of the DNS zones in a file during a TTL
+// to not list them again from one command to another
+func (c *OnsClient) EnableCache(cachePath string, ttl time.Duration) error {
+	cache, err := loadCache(cachePath, ttl)
+	if err != nil {
+		return err
+	}
+
+	c.cache = cache
+	c.provider = cachedProvider{Provider: c.provider, cache: cache}
+
+	return nil
+}
+
+// SetRefresh enables or disables the listing of the DNS zones. When disabled,
+// the records of a DNS zone are the cached records, even expired, or the state.
+func (c *OnsClient) SetRefresh(refresh bool) {
+	c.refresh = refresh
+}
+
+// SetLive enables or disables the listing of the DNS zones from the DNS provider,
+// skipping the cache and the refresh mode, to plan changes to apply right away
+func (c *OnsClient) SetLive(live bool) {
+	c.live = live
+}
+
+// SetDryRun enables or disables the dry run mode in which the DNS zone
+// and the state are never modified
+func (c *OnsClient) SetDryRun(dryRun bool) {
+	if p, ok := c.provider.(dryRunProvider); ok {
+		c.provider = p.Provider
+	}
+	if dryRun {
+		c.provider = dryRunProvider{c.provider}
+	}
+}
+
 // --
 
+// Zones lists the zones of the records of the config and the state
+func (c *OnsClient) Zones() []string {
+	return zonesOf(append(c.config.allRecords(), c.state.records...))
+}
+
 // Ls lists all records from a DNS zone by marking configured record with a star
 func (c *OnsClient) Ls(zone string) (Records, error) {
-	records, err := c.ListARecords(zone)
+	records, err := c.ListRecords(zone)
 	if err != nil {
 		return nil, err
 	}
 
+	configured := c.normalizeTargets(c.config.allRecords())
 	for i, r := range records {
-		if r.ExistsInBySubDomainAndTarget(c.config.records) {
+		if r.ExistsInBySubDomainAndTarget(configured) {
 			r.Managed = "*"
 			records[i] = r
 		}
@@ -70,17 +123,22 @@ func (c *OnsClient) Ls(zone string) (Records, error) {
 }
 
 // Add adds a new record in the config and plans the DNS config
-func (c *OnsClient) Add(zone string, subDomain string, target string) error {
-	record := Record{Zone: zone, SubDomain: subDomain, Target: target}
+func (c *OnsClient) Add(zone string, fieldType string, subDomain string, target string, ttl int) error {
+	record := Record{Zone: zone, FieldType: fieldType, SubDomain: subDomain, Target: target, TTL: ttl}
+
+	err := checkRecord(record)
+	if err != nil {
+		return err
+	}
 
-	if record.ExistsInBySubDomainAndTarget(c.config.records) {
-		return fmt.Errorf("Record `%s.%s %s` already added", record.SubDomain, record.Zone, record.Target)
+	if record.ExistsInBySubDomainAndTarget(c.config.allRecords()) {
+		return fmt.Errorf("Record `%s %s %s` already added", record.Name(), record.Type(), record.Target)
 		//return nil
 	}
 
 	c.config.records = append(c.config.records, record)
 
-	err := c.config.save()
+	err = c.config.save()
 	if err != nil {
 		return err
 	}
@@ -88,27 +146,145 @@ func (c *OnsClient) Add(zone string, subDomain string, target string) error {
 	return nil
 }
 
+// checkRecord validates a record added to the config
+func checkRecord(r Record) error {
+	if errs := validateRecord(r); len(errs) > 0 {
+		return fmt.Errorf("Invalid record: %s", strings.Join(errs, "; "))
+	}
+
+	return nil
+}
+
+// Import adds records of the DNS zone to the config and the state given a sub domain.
+// If all is true, all records of the DNS zone are imported.
+func (c *OnsClient) Import(zone string, subDomain string, all bool) (Records, error) {
+	dns, err := c.ListRecords(zone)
+	if err != nil {
+		return nil, err
+	}
+
+	found := false
+	imported := Records{}
+	for _, r := range dns {
+		if !all && r.SubDomain != subDomain {
+			continue
+		}
+		found = true
+
+		err = checkRecord(r)
+		if err != nil {
+			return nil, fmt.Errorf("Fail to import `%s %s %s`: %s", r.Name(), r.Type(), r.Target, err)
+		}
+
+		if !r.ExistsInBySubDomainAndTarget(c.state.records) {
+			c.state.records = append(c.state.records, r)
+		}
+
+		if r.ExistsInBySubDomainAndTarget(c.normalizeTargets(c.config.allRecords())) {
+			continue
+		}
+
+		record := r
+		record.ID = 0
+		c.config.records = append(c.config.records, record)
+		imported = append(imported, r)
+	}
+
+	if !found {
+		return nil, fmt.Errorf("No record `%s` in the DNS zone", Record{Zone: zone, SubDomain: subDomain}.Name())
+	}
+
+	err = c.config.save()
+	if err != nil {
+		return nil, err
+	}
+
+	err = c.state.save()
+	if err != nil {
+		return nil, err
+	}
+
+	err = c.state.snapshot()
+	if err != nil {
+		return nil, err
+	}
+
+	return imported, nil
+}
+
+// ImportZoneFile adds the records of a zone file in the RFC 1035 format to the config.
+// If the zone is empty, the zone is the first $ORIGIN of the file.
+func (c *OnsClient) ImportZoneFile(filepath string, zone string) (Records, error) {
+	records, err := LoadZoneFile(filepath, zone)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, r := range records {
+		err = checkRecord(r)
+		if err != nil {
+			return nil, fmt.Errorf("%s: Fail to import `%s %s %s`: %s", filepath, r.Name(), r.Type(), r.Target, err)
+		}
+	}
+
+	imported := Records{}
+	for _, r := range records {
+		if r.ExistsInBySubDomainAndTarget(c.config.allRecords()) {
+			continue
+		}
+
+		c.config.records = append(c.config.records, r)
+		imported = append(imported, r)
+	}
+
+	err = c.config.save()
+	if err != nil {
+		return nil, err
+	}
+
+	return imported, nil
+}
+
+// Export writes the records of a zone in the RFC 1035 zone file format. The records
+// are the configured records or, if live is true, the records of the DNS zone.
+func (c *OnsClient) Export(w io.Writer, zone string, live bool, ttl int) error {
+	records := Records(c.config.zoneRecords(zone))
+	if live {
+		var err error
+		records, err = c.ListRecords(zone)
+		if err != nil {
+			return err
+		}
+	}
+
+	sorted := append(Records{}, records...)
+	sort.Sort(sorted)
+
+	return WriteZoneFile(w, zone, ttl, sorted)
+}
+
 // Rm removes records from the config given a sub domain and plans the DNS config.
-// If the target is empty all records that match the sub domain will be removed.
-func (c *OnsClient) Rm(zone string, subDomain string, target string) error {
+// If the type or the target are empty all records that match the sub domain will be removed.
+func (c *OnsClient) Rm(zone string, fieldType string, subDomain string, target string) error {
 	record := Record{Zone: zone, SubDomain: subDomain}
 	newRecords := []Record{}
 
-	if !record.ExistsInBySubDomain(c.state.records) && !record.ExistsInBySubDomain(c.config.records) {
+	if !record.ExistsInBySubDomain(c.state.records) && !record.ExistsInBySubDomain(c.config.allRecords()) {
 		return fmt.Errorf("Record `%s.%s` not managed.", record.SubDomain, record.Zone)
 	}
 
+	// The included files are not modified
+	if r, ok := c.config.includedRecord(zone, fieldType, subDomain, target); ok {
+		return fmt.Errorf("Record `%s %s %s` is defined at %s, edit this file to remove it", r.Name(), r.Type(), r.Target, r.Source)
+	}
+
 	// Generate the new config without the record to remove
 	for i := len(c.config.records) - 1; i >= 0; i-- {
 		r := c.config.records[i]
-		if target == "" {
-			if r.Zone == zone && r.SubDomain == subDomain {
-				continue
-			}
-		} else {
-			if r.Zone == zone && r.SubDomain == subDomain && r.Target == target {
-				continue
-			}
+		if r.Zone == zone && r.SubDomain == subDomain &&
+			(fieldType == "" || r.Type() == fieldType) &&
+			(target == "" || r.Target == target) {
+			continue
 		}
 		newRecords = append(newRecords, r)
 	}
@@ -124,22 +300,33 @@ func (c *OnsClient) Rm(zone string, subDomain string, target string) error {
 }
 
 // Plan shows the DNS zone modifications to apply
-func (c *OnsClient) Plan(zone string) ([]Record, []Record, error) {
+func (c *OnsClient) Plan(zone string) (*Plan, error) {
+	return c.plan(zone, c.config.zoneRecords(zone), c.live)
+}
+
+// plan shows the DNS zone modifications to apply to get the desired records.
+// If live is true, the DNS zone is listed from the DNS provider.
+func (c *OnsClient) plan(zone string, desired []Record, live bool) (*Plan, error) {
 	var toAdd []Record
+	var toUpdate []Update
 	var toRm []Record
 	var state []Record
 
-	dns, err := c.ListARecords(zone)
+	dns, err := c.listRecords(zone, live)
 	if err != nil {
-		return nil, nil, err
+		return nil, err
 	}
 
+	// The desired records are compared with the records as the provider lists them
+	desired = c.normalizeTargets(desired)
+
 	touchState := false
 
 	// Plan to add record if it exists in the config
-	for _, r := range c.config.records {
+	for _, r := range desired {
 
-		isInDNS := r.ExistsInBySubDomainAndTarget(dns)
+		dnsRecord := r.GetBySubDomainAndTarget(dns)
+		isInDNS := dnsRecord != nil
 
 		// and not in the dns zone
 		if !isInDNS {
@@ -147,19 +334,25 @@ func (c *OnsClient) Plan(zone string) ([]Record, []Record, error) {
 			continue
 		}
 
-		isInState := r.ExistsInBySubDomainAndTarget(c.state.records)
+		// or in the dns zone with a different TTL
+		if c.ttl(*dnsRecord) != c.ttl(r) {
+			to := r
+			to.ID = dnsRecord.ID
+			toUpdate = append(toUpdate, Update{From: *dnsRecord, To: to})
+		}
+
+		isInState := r.ExistsInBySubDomainAndTarget(c.state.zoneRecords(zone))
 
 		// else if it's in the dns zone but not in the state
 		// refresh state
 		if isInDNS && !isInState {
-			record := r.GetBySubDomainAndTarget(dns)
-			state = append(state, *record)
+			state = append(state, *dnsRecord)
 			touchState = true
 		}
 	}
 
 	// Plan to remove records if it exists from the state
-	for _, r := range c.state.records {
+	for _, r := range c.state.zoneRecords(zone) {
 
 		// if not in the DNS zone, the DNS record might be
 		// removed from the DNS without ONS, plans to delete it
@@ -183,84 +376,266 @@ func (c *OnsClient) Plan(zone string) ([]Record, []Record, error) {
 
 		// Plan to remove record if it exists in the state
 		// and not in the config but in the dns zone
-		isInConfig := r.ExistsInBySubDomainAndTarget(c.config.records)
+		isInConfig := r.ExistsInBySubDomainAndTarget(desired)
 		if !isInConfig {
-			toRm = append(toRm, r)
+			toRm = append(toRm, *record)
 		}
 	}
 
+	// Plan to update records in place when a record replaces another one
+	// with the same sub domain and type
+	toAdd, toUpdate, toRm = planUpdates(toAdd, toUpdate, toRm)
+
 	if touchState {
-		c.state.records = state
+		c.state.setZoneRecords(zone, state)
 		err = c.state.save()
 		if err != nil {
-			return nil, nil, err
+			return nil, err
 		}
 	}
 
-	return toAdd, toRm, nil
+	return &Plan{
+		Zone:        zone,
+		Fingerprint: fingerprint(dns),
+		Unrefreshed: !live && !c.refresh,
+		ToAdd:       toAdd,
+		ToUpdate:    toUpdate,
+		ToRm:        toRm,
+	}, nil
 }
 
-var (
-	printAdd = color.New(color.Bold, color.FgGreen).PrintfFunc()
-	printRm  = color.New(color.Bold, color.FgRed).PrintfFunc()
-)
+// SetStateHistory sets the number of snapshots of the state to keep, 0 to keep none
+func (c *OnsClient) SetStateHistory(history int) {
+	c.state.history = history
+}
 
-// Apply applies the zone DNS configuration on the DNS zone
-func (c *OnsClient) Apply(zone string) (int, int, error) {
-	added := 0
-	removed := 0
+// StateVersion returns the serial and the lineage of the state
+func (c *OnsClient) StateVersion() (int64, string) {
+	return c.state.serial, c.state.lineage
+}
 
-	toAdd, toRm, err := c.Plan(zone)
+// Snapshots lists the snapshots of the state sorted by serial
+func (c *OnsClient) Snapshots() ([]Snapshot, error) {
+	return c.state.listSnapshots()
+}
+
+// RollbackPlans shows the DNS zones modifications to apply to get back to
+// the records of a snapshot of the state. The config is not modified.
+func (c *OnsClient) RollbackPlans(serial int64) ([]*Plan, error) {
+	records, err := c.state.loadSnapshot(serial)
 	if err != nil {
-		return 0, 0, err
+		return nil, err
 	}
 
-	for _, r := range toAdd {
-		newRecord, err := c.AddRecord(zone, r.SubDomain, r.Target)
+	plans := []*Plan{}
+	for _, zone := range zonesOf(append(append([]Record{}, records...), c.state.records...)) {
+		desired := []Record{}
+		for _, r := range recordsInZone(records, zone) {
+			r.ID = 0
+			desired = append(desired, r)
+		}
+
+		plan, err := c.plan(zone, desired, c.live)
 		if err != nil {
-			return 0, 0, err
+			return nil, err
 		}
+		plans = append(plans, plan)
+	}
 
-		c.state.records = append(c.state.records, *newRecord)
+	return plans, nil
+}
 
-		printAdd("%-16s %s.%s  added\n", r.Target, r.SubDomain, zone)
-		added++
+// Apply applies the zone DNS configuration on the DNS zone, listed from the DNS provider
+func (c *OnsClient) Apply(zone string) ([]Change, error) {
+	plan, err := c.plan(zone, c.config.zoneRecords(zone), true)
+	if err != nil {
+		return nil, err
 	}
 
-	for _, r := range toRm {
+	return c.snapshotChanges(c.applyPlan(plan))
+}
 
-		if r.ID != 0 {
-			_, err := c.DeleteRecordByID(zone, r.ID)
-			if err != nil {
-				return 0, 0, err
-			}
+// ApplyPlans applies saved plans on their DNS zone. It refuses to apply the plans
+// computed without listing the DNS zones or if one of the DNS zones, listed from
+// the DNS provider, has changed since the plans were computed.
+// The changes applied before an error are returned with the error.
+func (c *OnsClient) ApplyPlans(plans []*Plan) ([]Change, error) {
+	for _, plan := range plans {
+		if plan.Unrefreshed {
+			return nil, fmt.Errorf("The plan of zone `%s` was computed without listing the DNS zone, plan again with refresh", plan.Zone)
+		}
+
+		dns, err := c.listRecords(plan.Zone, true)
+		if err != nil {
+			return nil, err
+		}
+
+		if fingerprint(dns) != plan.Fingerprint {
+			return nil, fmt.Errorf("DNS zone `%s` has changed since the plan was computed, plan again", plan.Zone)
+		}
+	}
+
+	changes := []Change{}
+	for _, plan := range plans {
+		zoneChanges, err := c.applyPlan(plan)
+		changes = append(changes, zoneChanges...)
+		if err != nil {
+			return c.snapshotChanges(changes, err)
+		}
+	}
+
+	return c.snapshotChanges(changes, nil)
+}
+
+// snapshotChanges keeps a single snapshot of the state once changes have been
+// applied, even partially. The error of the apply prevails over the error of the snapshot.
+func (c *OnsClient) snapshotChanges(changes []Change, err error) ([]Change, error) {
+	if len(changes) == 0 {
+		return changes, err
+	}
+	if _, ok := c.provider.(dryRunProvider); ok {
+		return changes, err
+	}
+
+	snapshotErr := c.state.snapshot()
+	if err == nil {
+		err = snapshotErr
+	}
+
+	return changes, err
+}
+
+// applyPlan applies a plan on its DNS zone. The state is saved after each
+// modification so that a failure does not lose track of the modifications
+// already applied.
+func (c *OnsClient) applyPlan(plan *Plan) ([]Change, error) {
+	changes := []Change{}
+
+	zone := plan.Zone
+
+	// The records conflicting with the records to add are removed first
+	conflicting, toRm := splitConflicts(plan.ToAdd, plan.ToRm)
+	for _, r := range conflicting {
+		err := c.removeRecord(zone, r)
+		if err != nil {
+			return changes, err
+		}
+		changes = append(changes, Change{Action: Removed, Record: r})
+	}
+
+	for _, r := range plan.ToAdd {
+		newRecord, err := c.provider.Create(zone, r)
+		if err != nil {
+			return changes, err
+		}
+
+		c.state.records = append(c.state.records, *newRecord)
+
+		changes = append(changes, Change{Action: Added, Record: *newRecord})
+
+		err = c.saveState()
+		if err != nil {
+			return changes, err
+		}
+	}
+
+	for _, u := range plan.ToUpdate {
+		err := c.provider.Update(zone, u.To)
+		if err != nil {
+			return changes, err
 		}
 
 		for i, sr := range c.state.records {
-			if sr.Zone == r.Zone && sr.SubDomain == r.SubDomain && sr.Target == r.Target {
-				c.state.records = append(c.state.records[:i], c.state.records[i+1:]...)
+			if sr.ID == u.From.ID {
+				c.state.records[i] = u.To
 			}
 		}
 
-		printAdd("%-16s %s.%s  removed\n", r.Target, r.SubDomain, zone)
-		removed++
+		changes = append(changes, Change{Action: Updated, Record: u.To})
+
+		err = c.saveState()
+		if err != nil {
+			return changes, err
+		}
+	}
+
+	for _, r := range toRm {
+		err := c.removeRecord(zone, r)
+		if err != nil {
+			return changes, err
+		}
+		changes = append(changes, Change{Action: Removed, Record: r})
 	}
 
-	if (len(toAdd) + len(toRm)) == 0 {
+	if !plan.HasChanges() {
 		// No modification
-		return 0, 0, nil
+		return changes, nil
 	}
 
-	err = c.RefreshZone(zone)
+	err := c.provider.Refresh(zone)
 	if err != nil {
-		return 0, 0, err
+		return changes, err
 	}
 
-	err = c.state.save()
-	if err != nil {
+	return changes, nil
+}
+
+// ttl returns the TTL of a record, the default TTL of the provider if it has no TTL
+func (c *OnsClient) ttl(r Record) int {
+	if r.TTL == 0 {
+		return c.defaultTTL
+	}
+	return r.TTL
+}
+
+// removeRecord removes a record from the DNS zone and the state
+func (c *OnsClient) removeRecord(zone string, r Record) error {
+	if r.ID != 0 {
+		err := c.provider.Delete(zone, r.ID)
+		if err != nil {
+			return err
+		}
+	}
+
+	newRecords := []Record{}
+	for _, sr := range c.state.records {
+		if sr.Zone == r.Zone && sr.Type() == r.Type() && sr.SubDomain == r.SubDomain && sr.Target == r.Target {
+			continue
+		}
+		newRecords = append(newRecords, sr)
+	}
+	c.state.records = newRecords
+
+	return c.saveState()
+}
+
+// splitConflicts splits the records to remove between the records conflicting
+// with a record to add, a CNAME record not coexisting with other records of the
+// same name (e.g. an A record replaced by a CNAME record), and the others
+func splitConflicts(toAdd []Record, toRm []Record) ([]Record, []Record) {
+	var conflicting, others []Record
+	for _, r := range toRm {
+		conflict := false
+		for _, a := range toAdd {
+			if strings.EqualFold(a.SubDomain, r.SubDomain) && (a.Type() == "CNAME" || r.Type() == "CNAME") {
+				conflict = true
+				break
+			}
+		}
+		if conflict {
+			conflicting = append(conflicting, r)
+		} else {
+			others = append(others, r)
+		}
+	}
+	return conflicting, others
+}
 
-		return 0, 0, err
+// saveState saves the state, unless in dry run mode
+func (c *OnsClient) saveState() error {
+	if _, ok := c.provider.(dryRunProvider); ok {
+		return nil
 	}
 
-	return added, removed, nil
+	return c.state.save()
 }
@@ -0,0 +1,610 @@
+package client_test
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/thbkrkr/ons/backend/backendtest"
+	"github.com/thbkrkr/ons/client"
+	"github.com/thbkrkr/ons/provider/ovh"
+	"github.com/thbkrkr/ons/provider/ovh/ovhtest"
+)
+
+const zone = "bada.boum"
+
+// testEnv is an ons client using a fake OVH API and a config and a state
+// in a temporary directory
+type testEnv struct {
+	t          *testing.T
+	server     *ovhtest.Server
+	dir        string
+	configPath string
+}
+
+func newTestEnv(t *testing.T, config string) *testEnv {
+	dir, err := ioutil.TempDir("", "ons")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server := ovhtest.NewServer()
+	server.AddZone(zone)
+
+	e := &testEnv{t: t, server: server, dir: dir, configPath: filepath.Join(dir, "ons.config.json")}
+	e.writeConfig(config)
+	return e
+}
+
+func (e *testEnv) Close() {
+	e.server.Close()
+	os.RemoveAll(e.dir)
+}
+
+func (e *testEnv) writeConfig(config string) {
+	err := ioutil.WriteFile(e.configPath, []byte(config), 0644)
+	if err != nil {
+		e.t.Fatal(err)
+	}
+}
+
+func (e *testEnv) provider() *ovh.Provider {
+	provider, err := e.server.Provider()
+	if err != nil {
+		e.t.Fatal(err)
+	}
+	provider.Backoff = time.Millisecond
+	return provider
+}
+
+// client creates a client loading the config and the state
+func (e *testEnv) client() *client.OnsClient {
+	c, err := client.NewOnsClient(e.provider(), client.NewLocalBackend(e.dir), e.configPath, "", nil)
+	if err != nil {
+		e.t.Fatal(err)
+	}
+	return c
+}
+
+// records returns the records of the DNS zone as "subDomain type target ttl"
+func (e *testEnv) records() []string {
+	records := []string{}
+	for _, r := range e.server.Records(zone) {
+		records = append(records, fmt.Sprintf("%s %s %s %d", r.SubDomain, r.Type(), r.Target, r.TTL))
+	}
+	sort.Strings(records)
+	return records
+}
+
+// configured returns the configured records as "subDomain type target ttl"
+func (e *testEnv) configured() []string {
+	config, err := client.ValidateConfig(e.configPath, "", nil)
+	if err != nil {
+		e.t.Fatal(err)
+	}
+
+	records := []string{}
+	for _, r := range config {
+		records = append(records, fmt.Sprintf("%s %s %s %d", r.SubDomain, r.Type(), r.Target, r.TTL))
+	}
+	sort.Strings(records)
+	return records
+}
+
+// summary describes the changes of a plan, sorted
+func summary(plan *client.Plan) []string {
+	changes := []string{}
+	for _, r := range plan.ToAdd {
+		changes = append(changes, fmt.Sprintf("+ %s %s %s", r.SubDomain, r.Type(), r.Target))
+	}
+	for _, u := range plan.ToUpdate {
+		changes = append(changes, fmt.Sprintf("~ %s %s %s", u.To.SubDomain, u.To.Type(), u.Changes()))
+	}
+	for _, r := range plan.ToRm {
+		changes = append(changes, fmt.Sprintf("- %s %s %s", r.SubDomain, r.Type(), r.Target))
+	}
+	sort.Strings(changes)
+	return changes
+}
+
+func expectStrings(t *testing.T, what string, expected []string, actual []string) {
+	if !reflect.DeepEqual(expected, actual) {
+		t.Errorf("%s: expected %q, got %q", what, expected, actual)
+	}
+}
+
+func TestPlan(t *testing.T) {
+	e := newTestEnv(t, `{"bada.boum": [
+		{"subDomain": "bim", "target": "1.2.3.4"},
+		{"subDomain": "bam", "target": "1.2.3.5"},
+		{"subDomain": "bum", "target": "1.2.3.6"},
+		{"subDomain": "old", "target": "1.2.3.9"}
+	]}`)
+	defer e.Close()
+
+	plan, err := e.client().Plan(zone)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectStrings(t, "first plan", []string{
+		"+ bam A 1.2.3.5",
+		"+ bim A 1.2.3.4",
+		"+ bum A 1.2.3.6",
+		"+ old A 1.2.3.9",
+	}, summary(plan))
+
+	_, err = e.client().Apply(zone)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	e.writeConfig(`{"bada.boum": [
+		{"subDomain": "bim", "target": "1.2.3.4"},
+		{"subDomain": "bam", "target": "1.2.3.5", "ttl": 300},
+		{"subDomain": "bum", "target": "1.2.3.7"},
+		{"subDomain": "new", "target": "1.2.3.8"}
+	]}`)
+
+	plan, err = e.client().Plan(zone)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectStrings(t, "plan", []string{
+		"+ new A 1.2.3.8",
+		"- old A 1.2.3.9",
+		"~ bam A ttl: 0 => 300",
+		"~ bum A target: 1.2.3.6 => 1.2.3.7",
+	}, summary(plan))
+
+	_, err = e.client().Apply(zone)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectStrings(t, "records", []string{
+		"bam A 1.2.3.5 300",
+		"bim A 1.2.3.4 0",
+		"bum A 1.2.3.7 0",
+		"new A 1.2.3.8 0",
+	}, e.records())
+
+	plan, err = e.client().Plan(zone)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if plan.HasChanges() {
+		t.Errorf("expected no changes once applied, got %q", summary(plan))
+	}
+}
+
+func TestPlanTTLDrift(t *testing.T) {
+	e := newTestEnv(t, `{"bada.boum": [{"subDomain": "bim", "target": "1.2.3.4", "ttl": 300}]}`)
+	defer e.Close()
+
+	_, err := e.client().Apply(zone)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The TTL is modified without ons
+	record := e.server.Records(zone)[0]
+	record.TTL = 600
+	err = e.provider().Update(zone, record)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	plan, err := e.client().Plan(zone)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectStrings(t, "plan", []string{"~ bim A ttl: 600 => 300"}, summary(plan))
+	if plan.ToUpdate[0].To.ID != record.ID {
+		t.Errorf("expected an update of record %d, got %d", record.ID, plan.ToUpdate[0].To.ID)
+	}
+}
+
+func TestApplyReplaceByCNAME(t *testing.T) {
+	e := newTestEnv(t, `{"bada.boum": [{"subDomain": "www", "target": "1.2.3.4"}]}`)
+	defer e.Close()
+
+	_, err := e.client().Apply(zone)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	e.writeConfig(`{"bada.boum": [{"subDomain": "www", "fieldType": "CNAME", "target": "bim.bada.boum."}]}`)
+	_, err = e.client().Apply(zone)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectStrings(t, "records", []string{"www CNAME bim.bada.boum. 0"}, e.records())
+
+	e.writeConfig(`{"bada.boum": [{"subDomain": "www", "target": "1.2.3.5"}]}`)
+	_, err = e.client().Apply(zone)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectStrings(t, "records", []string{"www A 1.2.3.5 0"}, e.records())
+}
+
+func TestApplyPartialFailure(t *testing.T) {
+	e := newTestEnv(t, `{"bada.boum": [{"subDomain": "old", "target": "1.2.3.9"}]}`)
+	defer e.Close()
+
+	_, err := e.client().Apply(zone)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	e.writeConfig(`{"bada.boum": [
+		{"subDomain": "bim", "target": "1.2.3.4"},
+		{"subDomain": "bam", "target": "1.2.3.5"}
+	]}`)
+	e.server.Fail("DELETE", "/domain/zone/bada.boum/record/", http.StatusBadRequest, "Deletion refused", 1)
+
+	changes, err := e.client().Apply(zone)
+	if err == nil || !strings.Contains(err.Error(), "Deletion refused") {
+		t.Fatalf("expected the deletion to fail, got %v", err)
+	}
+	if len(changes) != 2 || changes[0].Action != client.Added || changes[1].Action != client.Added {
+		t.Errorf("expected the 2 records added before the failure, got %+v", changes)
+	}
+
+	// The state keeps track of the records created before the failure
+	plan, err := e.client().Plan(zone)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectStrings(t, "plan", []string{"- old A 1.2.3.9"}, summary(plan))
+
+	_, err = e.client().Apply(zone)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectStrings(t, "records", []string{"bam A 1.2.3.5 0", "bim A 1.2.3.4 0"}, e.records())
+}
+
+func TestAddRm(t *testing.T) {
+	e := newTestEnv(t, `{}`)
+	defer e.Close()
+
+	c := e.client()
+	err := c.Add(zone, "A", "bim", "1.2.3.4", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = c.Add(zone, "MX", "", "10 mx.bada.boum.", 300)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectStrings(t, "config", []string{" MX 10 mx.bada.boum. 300", "bim A 1.2.3.4 0"}, e.configured())
+
+	for _, invalid := range []struct {
+		fieldType, subDomain, target string
+		ttl                          int
+		err                          string
+	}{
+		{"A", "bim", "1.2.3.4", 0, "already added"},
+		{"A", "bam", "1.2.3.400", 0, "Invalid IPv4 address"},
+		{"A", "bam", "1.2.3.5", 30, "TTL 30 out of range"},
+		{"PTR", "bam", "bim.bada.boum.", 0, "not supported"},
+	} {
+		err = c.Add(zone, invalid.fieldType, invalid.subDomain, invalid.target, invalid.ttl)
+		if err == nil || !strings.Contains(err.Error(), invalid.err) {
+			t.Errorf("add %s %s: expected an error `%s`, got %v", invalid.subDomain, invalid.target, invalid.err, err)
+		}
+	}
+
+	_, err = e.client().Apply(zone)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c = e.client()
+	err = c.Rm(zone, "", "bim", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectStrings(t, "config", []string{" MX 10 mx.bada.boum. 300"}, e.configured())
+
+	err = c.Rm(zone, "", "bam", "")
+	if err == nil || !strings.Contains(err.Error(), "not managed") {
+		t.Errorf("expected an error removing a record not managed, got %v", err)
+	}
+
+	plan, err := e.client().Plan(zone)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectStrings(t, "plan", []string{"- bim A 1.2.3.4"}, summary(plan))
+}
+
+func TestListRecordError(t *testing.T) {
+	e := newTestEnv(t, `{"bada.boum": [{"subDomain": "bim", "target": "1.2.3.4"}]}`)
+	defer e.Close()
+
+	bim := e.server.AddRecord(zone, client.Record{SubDomain: "bim", Target: "1.2.3.4"})
+	e.server.AddRecord(zone, client.Record{SubDomain: "bam", Target: "1.2.3.5"})
+	path := fmt.Sprintf("/domain/zone/%s/record/%d", zone, bim.ID)
+
+	// Server errors are retried
+	e.server.Fail("GET", path, http.StatusServiceUnavailable, "Service unavailable", 2)
+	plan, err := e.client().Plan(zone)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if plan.HasChanges() {
+		t.Errorf("expected no changes, got %q", summary(plan))
+	}
+
+	// A record that can not be got fails the listing instead of planning
+	// to add it again
+	e.server.Fail("GET", path, http.StatusNotFound, "Record not found", 1)
+	_, err = e.client().Plan(zone)
+	if err == nil || !strings.Contains(err.Error(), "Fail to get 1 of 2 records") ||
+		!strings.Contains(err.Error(), "Record not found") {
+		t.Errorf("expected an error getting the record, got %v", err)
+	}
+}
+
+func TestInvalidSignature(t *testing.T) {
+	e := newTestEnv(t, `{}`)
+	defer e.Close()
+
+	provider, err := ovh.NewProvider(e.server.URL, ovhtest.AppKey, "wrong-secret", ovhtest.ConsumerKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = provider.List(zone)
+	if err == nil || !strings.Contains(err.Error(), "Invalid signature") {
+		t.Errorf("expected an invalid signature error, got %v", err)
+	}
+}
+
+// cachedClient creates a client caching the DNS zones
+func (e *testEnv) cachedClient() *client.OnsClient {
+	c := e.client()
+	err := c.EnableCache(filepath.Join(e.dir, "ons.cache.json"), 5*time.Minute)
+	if err != nil {
+		e.t.Fatal(err)
+	}
+	return c
+}
+
+func TestApplyPlansChangedZone(t *testing.T) {
+	e := newTestEnv(t, `{"bada.boum": [{"subDomain": "bim", "target": "1.2.3.4"}]}`)
+	defer e.Close()
+
+	plan, err := e.cachedClient().Plan(zone)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The DNS zone is modified without ons while it is cached
+	e.server.AddRecord(zone, client.Record{SubDomain: "bam", Target: "1.2.3.5"})
+
+	_, err = e.cachedClient().ApplyPlans([]*client.Plan{plan})
+	if err == nil || !strings.Contains(err.Error(), "has changed since the plan was computed") {
+		t.Errorf("expected the plan to be refused, got %v", err)
+	}
+	expectStrings(t, "records", []string{"bam A 1.2.3.5 0"}, e.records())
+}
+
+func TestLivePlan(t *testing.T) {
+	e := newTestEnv(t, `{"bada.boum": [{"subDomain": "bim", "target": "1.2.3.4"}]}`)
+	defer e.Close()
+
+	_, err := e.cachedClient().Plan(zone)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The record is created without ons while the DNS zone is cached
+	e.server.AddRecord(zone, client.Record{SubDomain: "bim", Target: "1.2.3.4"})
+
+	plan, err := e.cachedClient().Plan(zone)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectStrings(t, "cached plan", []string{"+ bim A 1.2.3.4"}, summary(plan))
+
+	c := e.cachedClient()
+	c.SetLive(true)
+	plan, err = c.Plan(zone)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectStrings(t, "live plan", []string{}, summary(plan))
+
+	changes, err := e.cachedClient().Apply(zone)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(changes) != 0 {
+		t.Errorf("expected no changes, got %+v", changes)
+	}
+	expectStrings(t, "records", []string{"bim A 1.2.3.4 0"}, e.records())
+}
+
+func TestApplyPlansUnrefreshed(t *testing.T) {
+	e := newTestEnv(t, `{"bada.boum": [{"subDomain": "bim", "target": "1.2.3.4"}]}`)
+	defer e.Close()
+
+	c := e.client()
+	c.SetRefresh(false)
+	plan, err := c.Plan(zone)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = e.client().ApplyPlans([]*client.Plan{plan})
+	if err == nil || !strings.Contains(err.Error(), "computed without listing the DNS zone") {
+		t.Errorf("expected the plan to be refused, got %v", err)
+	}
+	expectStrings(t, "records", []string{}, e.records())
+}
+
+func TestSavePlans(t *testing.T) {
+	e := newTestEnv(t, `{"bada.boum": [{"subDomain": "bim", "target": "1.2.3.4"}]}`)
+	defer e.Close()
+
+	plan, err := e.client().Plan(zone)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	planPath := filepath.Join(e.dir, "plan.json")
+	err = client.SavePlans(planPath, []*client.Plan{plan})
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadFile(planPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, list := range []string{`"toUpdate": []`, `"toRm": []`} {
+		if !strings.Contains(string(data), list) {
+			t.Errorf("expected %s in the plan file, got %s", list, data)
+		}
+	}
+
+	plans, err := client.LoadPlans(planPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(plans) != 1 {
+		t.Fatalf("expected 1 plan, got %d", len(plans))
+	}
+	expectStrings(t, "loaded plan", summary(plan), summary(plans[0]))
+}
+
+func TestLocalBackend(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ons-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	backendtest.Run(t, client.NewLocalBackend(dir))
+	backendtest.RunInvalidLock(t, client.NewLocalBackend(dir), "ons.lock")
+}
+
+func TestStateSnapshots(t *testing.T) {
+	e := newTestEnv(t, `{"bada.boum": [
+		{"subDomain": "bim", "target": "1.2.3.4"},
+		{"subDomain": "bam", "target": "1.2.3.5"},
+		{"subDomain": "boum", "target": "1.2.3.6"},
+		{"subDomain": "bada", "target": "1.2.3.7"},
+		{"subDomain": "bidi", "target": "1.2.3.8"}
+	]}`)
+	defer e.Close()
+
+	e.server.AddRecord(zone, client.Record{SubDomain: "bim", Target: "1.2.3.4"})
+
+	c := e.client()
+	c.SetStateHistory(3)
+
+	snapshots, err := c.Snapshots()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(snapshots) != 1 {
+		t.Fatalf("expected the snapshot of the new state, got %+v", snapshots)
+	}
+	initial := snapshots[0].Serial
+
+	// The plan refreshes the state without keeping a snapshot
+	_, err = c.Plan(zone)
+	if err != nil {
+		t.Fatal(err)
+	}
+	serial, _ := c.StateVersion()
+	if serial == initial {
+		t.Fatalf("expected the plan to save the state")
+	}
+	snapshots, err = c.Snapshots()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(snapshots) != 1 {
+		t.Errorf("expected no snapshot of the plan, got %+v", snapshots)
+	}
+
+	// The apply saves the state at each change and keeps a single snapshot
+	changes, err := c.Apply(zone)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(changes) != 4 {
+		t.Fatalf("expected 4 changes, got %+v", changes)
+	}
+	serial, _ = c.StateVersion()
+	snapshots, err = c.Snapshots()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(snapshots) != 2 || snapshots[0].Serial != initial || snapshots[1].Serial != serial {
+		t.Fatalf("expected the snapshots %d and %d, got %+v", initial, serial, snapshots)
+	}
+
+	plans, err := c.RollbackPlans(initial)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = c.ApplyPlans(plans)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectStrings(t, "records", []string{}, e.records())
+
+	serial, _ = c.StateVersion()
+	snapshots, err = c.Snapshots()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(snapshots) != 3 || snapshots[0].Serial != initial || snapshots[2].Serial != serial {
+		t.Errorf("expected a snapshot of the rollback, got %+v", snapshots)
+	}
+}
+
+func TestImportInvalidRecord(t *testing.T) {
+	e := newTestEnv(t, `{"bada.boum": [{"subDomain": "bim", "target": "1.2.3.4"}]}`)
+	defer e.Close()
+
+	zoneFile := filepath.Join(e.dir, "bada.boum.zone")
+	for _, invalid := range []struct {
+		entry string
+		err   string
+	}{
+		{"bam IN A 1.2.3.400", "Invalid IPv4 address"},
+		{"bam IN AAAA 2001:db8::g", "Invalid IPv6 address"},
+		{"bam 30 IN A 1.2.3.5", "TTL 30 out of range"},
+	} {
+		err := ioutil.WriteFile(zoneFile, []byte("$ORIGIN bada.boum.\n"+invalid.entry+"\n"), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		_, err = e.client().ImportZoneFile(zoneFile, "")
+		if err == nil || !strings.Contains(err.Error(), invalid.err) {
+			t.Errorf("import `%s`: expected an error `%s`, got %v", invalid.entry, invalid.err, err)
+		}
+	}
+
+	e.server.AddRecord(zone, client.Record{SubDomain: "bam", Target: "1.2.3.400"})
+
+	_, err := e.client().Import(zone, "", true)
+	if err == nil || !strings.Contains(err.Error(), "Fail to import `bam.bada.boum A 1.2.3.400`: Invalid record: Invalid IPv4 address") {
+		t.Errorf("expected an invalid record error, got %v", err)
+	}
+
+	expectStrings(t, "config", []string{"bim A 1.2.3.4 0"}, e.configured())
+}
@@ -1,23 +1,375 @@
 package client
 
-// DNSConfig represents a DNS zone records configuration
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// DNSConfig represents a DNS zone records configuration: the records of the
+// config file and the records of the files it includes, which are never saved
 type DNSConfig struct {
 	configPath string
 	records    []Record
+	included   []Record
+
+	// includes are the paths and the patterns of the included files,
+	// as written in the config file
+	includes []string
+
+	// templated is true if the config is a template, which can not be saved
+	templated bool
+}
+
+// ConfigError is the error returned when loading an invalid config.
+// It lists every error found, prefixed by the file and the line.
+type ConfigError struct {
+	Errors []string
+}
+
+func (e *ConfigError) Error() string {
+	return strings.Join(e.Errors, "; ")
 }
 
-func loadConfig(configPath string) (*DNSConfig, error) {
-	records, err := loadRecords(configPath)
+// configFields lists the fields of a record in a config
+var configFields = []string{"zone", "subDomain", "target", "ttl", "fieldType", "id"}
+
+func loadConfig(configPath string, defaultZone string, vars Vars) (*DNSConfig, error) {
+	config, err := readConfig(configPath, vars)
 	if err != nil {
 		return nil, err
 	}
 
-	return &DNSConfig{
-		configPath: configPath,
-		records:    records,
-	}, nil
+	errs := setDefaultZone(config.records, defaultZone)
+	errs = append(errs, setDefaultZone(config.included, defaultZone)...)
+	if len(errs) > 0 {
+		return nil, &ConfigError{Errors: errs}
+	}
+
+	if errs := checkDuplicates(config.allRecords()); len(errs) > 0 {
+		return nil, &ConfigError{Errors: errs}
+	}
+
+	return config, nil
 }
 
+// setDefaultZone sets the zone of the records of a config in the array format
+// not defining their zone
+func setDefaultZone(records []Record, defaultZone string) []string {
+	errs := []string{}
+	for i, r := range records {
+		if r.Zone == "" {
+			if defaultZone == "" {
+				errs = append(errs, fmt.Sprintf("%s: Record `%s %s` has no zone, set ONS_ZONE or group records by zone",
+					r.Source, r.Type(), r.Target))
+				continue
+			}
+			records[i].Zone = defaultZone
+		}
+	}
+	return errs
+}
+
+// save saves the config in the format given by the extension of its path
 func (c *DNSConfig) save() error {
-	return saveRecords(c.configPath, c.records)
+	if c.templated {
+		return fmt.Errorf("%s is a template, edit it to change the configured records", c.configPath)
+	}
+
+	data, err := encodeConfig(configFormat(c.configPath), c.records, c.includes)
+	if err != nil {
+		return err
+	}
+
+	return writeFile(c.configPath, data, 0644)
+}
+
+// allRecords returns the records of the config file and of the included files
+func (c *DNSConfig) allRecords() []Record {
+	return append(append([]Record{}, c.records...), c.included...)
+}
+
+// zoneRecords returns the configured records of a zone
+func (c *DNSConfig) zoneRecords(zone string) []Record {
+	return recordsInZone(c.allRecords(), zone)
+}
+
+// includedRecord returns a record of the included files matching a record
+// to remove, given its sub domain and optionally its type and its target
+func (c *DNSConfig) includedRecord(zone string, fieldType string, subDomain string, target string) (Record, bool) {
+	for _, r := range c.included {
+		if r.Zone == zone && r.SubDomain == subDomain &&
+			(fieldType == "" || r.Type() == fieldType) &&
+			(target == "" || r.Target == target) {
+			return r, true
+		}
+	}
+	return Record{}, false
+}
+
+// readConfig reads a config and merges the records of the files it includes
+// with the include directive and of the files of the RecordsDir directory
+// next to it. Each file is read once, whatever the number of includes.
+func readConfig(configPath string, vars Vars) (*DNSConfig, error) {
+	file, err := readConfigFile(configPath, vars)
+	if err != nil && !isConfigError(err) {
+		return nil, err
+	}
+
+	config := &DNSConfig{configPath: configPath, records: file.records, includes: file.includes, templated: file.templated}
+	errs := configErrors(err)
+
+	recordFiles := []string{}
+	for _, ext := range ConfigFormats {
+		paths, _ := filepath.Glob(filepath.Join(filepath.Dir(configPath), RecordsDir, "*."+ext))
+		recordFiles = append(recordFiles, paths...)
+	}
+	sort.Strings(recordFiles)
+
+	read := map[string]bool{filepath.Clean(configPath): true}
+	pending := append(file.includedFiles, recordFiles...)
+	for len(pending) > 0 {
+		path := pending[0]
+		pending = pending[1:]
+		if read[filepath.Clean(path)] {
+			continue
+		}
+		read[filepath.Clean(path)] = true
+
+		included, err := readConfigFile(path, vars)
+		if err != nil && !isConfigError(err) {
+			return nil, err
+		}
+		errs = append(errs, configErrors(err)...)
+
+		config.included = append(config.included, included.records...)
+		pending = append(pending, included.includedFiles...)
+	}
+
+	if len(errs) > 0 {
+		return nil, &ConfigError{Errors: errs}
+	}
+
+	return config, nil
+}
+
+// configFile is a file of a config: its records and the files it includes
+type configFile struct {
+	records       []Record
+	includes      []string
+	includedFiles []string
+	templated     bool
+}
+
+// readConfigFile reads a config file in JSON, YAML or TOML given the
+// extension of its path, expands it as a template with variables, validates
+// its records and finds the files it includes. The lines of a templated config
+// are the lines once expanded. The errors of the config are returned in a
+// *ConfigError along with the valid records and includes.
+func readConfigFile(configPath string, vars Vars) (*configFile, error) {
+	source, err := ioutil.ReadFile(configPath)
+	if err != nil {
+		return nil, err
+	}
+
+	file := &configFile{}
+
+	data, err := expandConfig(configPath, source, vars)
+	if err != nil {
+		return file, &ConfigError{Errors: []string{"Invalid template: " + err.Error()}}
+	}
+	file.templated = !bytes.Equal(data, source)
+
+	name := configPath
+	if file.templated {
+		name += " (expanded)"
+	}
+
+	raws, includes, err := parseConfig(configFormat(configPath), data)
+	if err != nil {
+		line := 0
+		if e, ok := err.(*lineError); ok {
+			line = e.line
+		}
+		return file, &ConfigError{Errors: []string{position(name, line) + ": " + err.Error()}}
+	}
+
+	errs := []string{}
+	invalidZones := map[string]bool{}
+	records := []Record{}
+	for _, raw := range raws {
+		if raw.zone != "" && !invalidZones[raw.zone] {
+			if err := checkHostname(raw.zone); err != nil {
+				invalidZones[raw.zone] = true
+				errs = append(errs, fmt.Sprintf("%s: Invalid zone `%s`: %s", position(name, raw.line), raw.zone, err))
+			}
+		}
+
+		r, decodeErrs := raw.decode()
+		for _, e := range decodeErrs {
+			errs = append(errs, position(name, e.line)+": "+e.msg)
+		}
+		if len(decodeErrs) > 0 {
+			continue
+		}
+
+		r.Source = position(name, raw.line)
+		for _, msg := range validateRecord(r) {
+			errs = append(errs, r.Source+": "+msg)
+		}
+
+		records = append(records, r)
+	}
+
+	for _, include := range includes {
+		file.includes = append(file.includes, include.pattern)
+
+		pattern := include.pattern
+		if !filepath.IsAbs(pattern) {
+			pattern = filepath.Join(filepath.Dir(configPath), pattern)
+		}
+
+		paths, err := filepath.Glob(pattern)
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("%s: Invalid include `%s`: %s", position(name, include.line), include.pattern, err))
+			continue
+		}
+		// A pattern may match no file, unlike a path
+		if len(paths) == 0 && !strings.ContainsAny(include.pattern, "*?[") {
+			errs = append(errs, fmt.Sprintf("%s: Included file `%s` not found", position(name, include.line), include.pattern))
+		}
+		file.includedFiles = append(file.includedFiles, paths...)
+	}
+
+	file.records = records
+	if len(errs) > 0 {
+		return file, &ConfigError{Errors: errs}
+	}
+
+	return file, nil
+}
+
+func isConfigError(err error) bool {
+	_, ok := err.(*ConfigError)
+	return ok
+}
+
+// configErrors returns the errors of a *ConfigError, none for a nil error
+func configErrors(err error) []string {
+	if e, ok := err.(*ConfigError); ok {
+		return e.Errors
+	}
+	return nil
+}
+
+// decode decodes a raw record, rejecting the unknown fields and the values of a wrong type
+func (raw rawRecord) decode() (Record, []*lineError) {
+	r := Record{Zone: raw.zone}
+	if raw.fields == nil {
+		return r, []*lineError{{raw.line, "Invalid record, expecting an object"}}
+	}
+
+	keys := []string{}
+	for key := range raw.fields {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	errs := []*lineError{}
+	for _, key := range keys {
+		value := raw.fields[key]
+		line := raw.lines[key]
+		if line == 0 {
+			line = raw.line
+		}
+
+		var ok bool
+		expected := "a string"
+		switch {
+		case key == "zone" && raw.zone == "":
+			r.Zone, ok = value.(string)
+		case key == "subDomain":
+			r.SubDomain, ok = value.(string)
+		case key == "target":
+			r.Target, ok = value.(string)
+		case key == "fieldType":
+			r.FieldType, ok = value.(string)
+		case key == "ttl":
+			var ttl int64
+			ttl, ok = toInt(value)
+			r.TTL = int(ttl)
+			expected = "an integer"
+		case key == "id":
+			r.ID, ok = toInt(value)
+			expected = "an integer"
+		default:
+			errs = append(errs, &lineError{line, unknownField(key, raw.zone != "")})
+			continue
+		}
+
+		if !ok {
+			errs = append(errs, &lineError{line, fmt.Sprintf("Invalid value `%v` for field `%s`, expecting %s",
+				value, key, expected)})
+		}
+	}
+
+	if _, ok := raw.fields["target"]; !ok {
+		errs = append(errs, &lineError{raw.line, "Missing field `target`"})
+	}
+
+	if r.FieldType == "" {
+		r.FieldType = DefaultFieldType
+	}
+
+	return r, errs
+}
+
+// unknownField returns the error of an unknown field, suggesting the field
+// differing only by its case
+func unknownField(key string, grouped bool) string {
+	msg := fmt.Sprintf("Unknown field `%s`", key)
+	if key == "zone" && grouped {
+		return msg + ", the zone of the records grouped by zone is their group"
+	}
+
+	fields := configFields
+	if grouped {
+		fields = configFields[1:]
+	}
+	for _, field := range fields {
+		if strings.EqualFold(field, key) {
+			return msg + fmt.Sprintf(", did you mean `%s`?", field)
+		}
+	}
+	return msg + ", use one of " + strings.Join(fields, ", ")
+}
+
+// toInt converts an integer decoded from a config
+func toInt(value interface{}) (int64, bool) {
+	switch v := value.(type) {
+	case int:
+		return int64(v), true
+	case int64:
+		return v, true
+	case uint64:
+		return int64(v), v <= math.MaxInt64
+	case float64:
+		return int64(v), v == float64(int64(v))
+	case json.Number:
+		i, err := v.Int64()
+		return i, err == nil
+	}
+	return 0, false
+}
+
+func position(path string, line int) string {
+	if line <= 0 {
+		return path
+	}
+	return fmt.Sprintf("%s:%d", path, line)
 }
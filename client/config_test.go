@@ -0,0 +1,167 @@
+package client_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+
+	"github.com/thbkrkr/ons/client"
+)
+
+func TestConfigErrors(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ons")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	for _, test := range []struct {
+		name     string
+		config   string
+		expected []string
+	}{
+		{"ons.config.json", `{"bada.boum": [
+  {"subdomain": "bim", "target": "1.2.3.4"},
+  {"subDomain": "bam", "target": "1.2.3.400"},
+  {"subDomain": "boum", "fieldType": "AAAA",
+   "target": "2001:db8::g"},
+  {"subDomain": "bada", "target": "1.2.3.5",
+   "ttl": 30}
+]}
+`, []string{
+			"ons.config.json:2: Unknown field `subdomain`, did you mean `subDomain`?",
+			"ons.config.json:3: Invalid IPv4 address `1.2.3.400` for an A record",
+			"ons.config.json:4: Invalid IPv6 address `2001:db8::g` for an AAAA record",
+			"ons.config.json:6: TTL 30 out of range, use 0 for the default TTL or a TTL between 60 and 2147483647",
+		}},
+		{"ons.config.yaml", `bada.boum:
+  - subdomain: bim
+    target: 1.2.3.4
+  - subDomain: bam
+    target: 1.2.3.400
+  - subDomain: boum
+    fieldType: AAAA
+    target: 2001:db8::g
+  - subDomain: bada
+    target: 1.2.3.5
+    ttl: 30
+`, []string{
+			"ons.config.yaml:2: Unknown field `subdomain`, did you mean `subDomain`?",
+			"ons.config.yaml:4: Invalid IPv4 address `1.2.3.400` for an A record",
+			"ons.config.yaml:6: Invalid IPv6 address `2001:db8::g` for an AAAA record",
+			"ons.config.yaml:9: TTL 30 out of range, use 0 for the default TTL or a TTL between 60 and 2147483647",
+		}},
+		{"ons.config.toml", `[["bada.boum"]]
+subdomain = "bim"
+target = "1.2.3.4"
+
+[["bada.boum"]]
+subDomain = "bam"
+target = "1.2.3.400"
+
+[["bada.boum"]]
+subDomain = "boum"
+fieldType = "AAAA"
+target = "2001:db8::g"
+
+[["bada.boum"]]
+subDomain = "bada"
+target = "1.2.3.5"
+ttl = 30
+`, []string{
+			"ons.config.toml:2: Unknown field `subdomain`, did you mean `subDomain`?",
+			"ons.config.toml:5: Invalid IPv4 address `1.2.3.400` for an A record",
+			"ons.config.toml:9: Invalid IPv6 address `2001:db8::g` for an AAAA record",
+			"ons.config.toml:14: TTL 30 out of range, use 0 for the default TTL or a TTL between 60 and 2147483647",
+		}},
+	} {
+		path := filepath.Join(dir, test.name)
+		err := ioutil.WriteFile(path, []byte(test.config), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		_, err = client.ValidateConfig(path, "", nil)
+		configErr, ok := err.(*client.ConfigError)
+		if !ok {
+			t.Errorf("%s: expected a *client.ConfigError, got %#v", test.name, err)
+			continue
+		}
+
+		expected := []string{}
+		for _, e := range test.expected {
+			expected = append(expected, filepath.Join(dir, e))
+		}
+		expectStrings(t, test.name, expected, configErr.Errors)
+
+		os.Remove(path)
+	}
+}
+
+func TestConfigMergeDuplicate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ons")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	for name, config := range map[string]string{
+		"ons.config.json": `{
+  "include": ["mail.toml"],
+  "bada.boum": [{"subDomain": "www", "fieldType": "CNAME", "target": "bim.bada.boum."}]
+}
+`,
+		"mail.toml": `[["bada.boum"]]
+subDomain = "mx"
+target = "1.2.3.5"
+
+[["bada.boum"]]
+subDomain = "bim"
+target = "1.2.3.4"
+`,
+		"records.d/bim.yaml": `bada.boum:
+  - subDomain: bam
+    target: 1.2.3.6
+  - subDomain: bim
+    target: 1.2.3.4
+`,
+	} {
+		path := filepath.Join(dir, name)
+		err = os.MkdirAll(filepath.Dir(path), 0755)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = ioutil.WriteFile(path, []byte(config), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	_, err = client.ValidateConfig(filepath.Join(dir, "ons.config.json"), "", nil)
+	configErr, ok := err.(*client.ConfigError)
+	if !ok {
+		t.Fatalf("expected a *client.ConfigError, got %#v", err)
+	}
+	expectStrings(t, "errors", []string{
+		filepath.Join(dir, "records.d/bim.yaml") + ":4: Duplicate record `bim.bada.boum A 1.2.3.4`, already defined at " +
+			filepath.Join(dir, "mail.toml") + ":5",
+	}, configErr.Errors)
+
+	// Without the duplicate, the records of all files are merged
+	err = ioutil.WriteFile(filepath.Join(dir, "records.d/bim.yaml"), []byte("bada.boum:\n  - subDomain: bam\n    target: 1.2.3.6\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	records, err := client.ValidateConfig(filepath.Join(dir, "ons.config.json"), "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	merged := []string{}
+	for _, r := range records {
+		merged = append(merged, r.SubDomain+" "+r.Source[len(dir)+1:])
+	}
+	sort.Strings(merged)
+	expectStrings(t, "records", []string{"bam records.d/bim.yaml:2", "bim mail.toml:5", "mx mail.toml:1", "www ons.config.json:3"}, merged)
+}
@@ -0,0 +1,580 @@
+package client
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	toml "github.com/pelletier/go-toml"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// ConfigFormats lists the extensions of the config files, by order of lookup
+var ConfigFormats = []string{"json", "yaml", "yml", "toml"}
+
+// FindConfig returns the path of the config of a directory: the first
+// ons.config file found with a supported extension, ons.config.json by default
+func FindConfig(dir string) string {
+	for _, ext := range ConfigFormats {
+		path := filepath.Join(dir, "ons.config."+ext)
+		if _, err := os.Stat(path); err == nil {
+			return path
+		}
+	}
+	return filepath.Join(dir, "ons.config.json")
+}
+
+// RecordsDir is the directory of the config files merged in the config,
+// relative to the directory of the config
+const RecordsDir = "records.d"
+
+// includeKey is the key listing the files to merge in a config grouped by zone
+const includeKey = "include"
+
+// configInclude is a path or a pattern of files included by a config,
+// relative to the directory of the config
+type configInclude struct {
+	pattern string
+	line    int
+}
+
+// configFormat returns the format of a config given its path, JSON by default
+func configFormat(path string) string {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		return "yaml"
+	case ".toml":
+		return "toml"
+	}
+	return "json"
+}
+
+// rawRecord is a record read from a config before its decoding.
+// Its fields are nil if the record is not an object.
+type rawRecord struct {
+	zone   string
+	line   int
+	fields map[string]interface{}
+	lines  map[string]int
+}
+
+func newRawRecord(zone string, line int) rawRecord {
+	return rawRecord{zone: zone, line: line, fields: map[string]interface{}{}, lines: map[string]int{}}
+}
+
+// lineError is an error at a line of a config, 0 if unknown
+type lineError struct {
+	line int
+	msg  string
+}
+
+func (e *lineError) Error() string {
+	return e.msg
+}
+
+// parseConfig parses the records and the includes of a config
+func parseConfig(format string, data []byte) ([]rawRecord, []configInclude, error) {
+	switch format {
+	case "yaml":
+		return parseYAMLConfig(data)
+	case "toml":
+		return parseTOMLConfig(data)
+	}
+	return parseJSONConfig(data)
+}
+
+// encodeConfig encodes records grouped by zone and the includes in the format of a config
+func encodeConfig(format string, records []Record, includes []string) ([]byte, error) {
+	switch format {
+	case "yaml":
+		return yaml.Marshal(withIncludes(groupRecords(records), includes))
+	case "toml":
+		return encodeTOMLConfig(records, includes), nil
+	}
+	if len(includes) == 0 {
+		return encodeRecords(records)
+	}
+	return json.MarshalIndent(withIncludes(groupRecords(records), includes), "", "  ")
+}
+
+// withIncludes returns records grouped by zone along with the includes
+func withIncludes(zones map[string][]Record, includes []string) map[string]interface{} {
+	config := map[string]interface{}{}
+	for zone, records := range zones {
+		config[zone] = records
+	}
+	if len(includes) > 0 {
+		config[includeKey] = includes
+	}
+	return config
+}
+
+// writeIncludes writes the includes of a TOML config
+func writeIncludes(buf *bytes.Buffer, includes []string) {
+	if len(includes) == 0 {
+		return
+	}
+	paths := []string{}
+	for _, include := range includes {
+		paths = append(paths, quote(include))
+	}
+	fmt.Fprintf(buf, "%s = [%s]\n\n", includeKey, strings.Join(paths, ", "))
+}
+
+// JSON
+
+// parseJSONConfig parses a JSON config, records being either grouped by zone
+// in an object or listed in an array
+func parseJSONConfig(data []byte) ([]rawRecord, []configInclude, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+
+	tok, err := dec.Token()
+	if err == io.EOF {
+		return nil, nil, nil
+	}
+	if err != nil {
+		return nil, nil, jsonError(data, dec, err)
+	}
+
+	var raws []rawRecord
+	var includes []configInclude
+	switch tok {
+	case json.Delim('['):
+		raws, err = parseJSONRecords(data, dec, "")
+		if err != nil {
+			return nil, nil, err
+		}
+	case json.Delim('{'):
+		for dec.More() {
+			line := jsonLine(data, dec.InputOffset())
+			tok, err = dec.Token()
+			if err != nil {
+				return nil, nil, jsonError(data, dec, err)
+			}
+			zone := tok.(string)
+
+			tok, err = dec.Token()
+			if err != nil {
+				return nil, nil, jsonError(data, dec, err)
+			}
+			if tok != json.Delim('[') {
+				if zone == includeKey {
+					return nil, nil, &lineError{line, "Includes must be an array of paths"}
+				}
+				return nil, nil, &lineError{line, fmt.Sprintf("Records of zone `%s` must be an array", zone)}
+			}
+
+			if zone == includeKey {
+				paths, err := parseJSONIncludes(data, dec)
+				if err != nil {
+					return nil, nil, err
+				}
+				includes = append(includes, paths...)
+				continue
+			}
+
+			records, err := parseJSONRecords(data, dec, zone)
+			if err != nil {
+				return nil, nil, err
+			}
+			raws = append(raws, records...)
+		}
+		if _, err = dec.Token(); err != nil {
+			return nil, nil, jsonError(data, dec, err)
+		}
+	default:
+		return nil, nil, &lineError{1, "Records must be grouped by zone in an object or listed in an array"}
+	}
+
+	if _, err = dec.Token(); err != io.EOF {
+		return nil, nil, &lineError{jsonLine(data, dec.InputOffset()), "Unexpected data after the records"}
+	}
+
+	return raws, includes, nil
+}
+
+// parseJSONIncludes parses the paths of an array, up to its closing bracket
+func parseJSONIncludes(data []byte, dec *json.Decoder) ([]configInclude, error) {
+	includes := []configInclude{}
+	for dec.More() {
+		line := jsonLine(data, dec.InputOffset())
+		var value interface{}
+		err := dec.Decode(&value)
+		if err != nil {
+			return nil, jsonError(data, dec, err)
+		}
+		path, ok := value.(string)
+		if !ok {
+			return nil, &lineError{line, fmt.Sprintf("Invalid include `%v`, expecting a path", value)}
+		}
+		includes = append(includes, configInclude{path, line})
+	}
+
+	if _, err := dec.Token(); err != nil {
+		return nil, jsonError(data, dec, err)
+	}
+
+	return includes, nil
+}
+
+// parseJSONRecords parses the records of an array, up to its closing bracket
+func parseJSONRecords(data []byte, dec *json.Decoder, zone string) ([]rawRecord, error) {
+	raws := []rawRecord{}
+	for dec.More() {
+		line := jsonLine(data, dec.InputOffset())
+		tok, err := dec.Token()
+		if err != nil {
+			return nil, jsonError(data, dec, err)
+		}
+		if tok != json.Delim('{') {
+			if _, ok := tok.(json.Delim); ok {
+				return nil, &lineError{line, "Invalid record, expecting an object"}
+			}
+			raws = append(raws, rawRecord{zone: zone, line: line})
+			continue
+		}
+
+		raw := newRawRecord(zone, line)
+		for dec.More() {
+			line := jsonLine(data, dec.InputOffset())
+			tok, err := dec.Token()
+			if err != nil {
+				return nil, jsonError(data, dec, err)
+			}
+			key := tok.(string)
+
+			var value interface{}
+			err = dec.Decode(&value)
+			if err != nil {
+				return nil, jsonError(data, dec, err)
+			}
+			if _, ok := raw.fields[key]; ok {
+				return nil, &lineError{line, fmt.Sprintf("Duplicate field `%s`", key)}
+			}
+			raw.fields[key] = value
+			raw.lines[key] = line
+		}
+		if _, err = dec.Token(); err != nil {
+			return nil, jsonError(data, dec, err)
+		}
+
+		raws = append(raws, raw)
+	}
+
+	if _, err := dec.Token(); err != nil {
+		return nil, jsonError(data, dec, err)
+	}
+
+	return raws, nil
+}
+
+// jsonLine returns the line of the first value following an offset
+func jsonLine(data []byte, offset int64) int {
+	i := int(offset)
+	for i < len(data) && strings.IndexByte(" \t\r\n,:", data[i]) >= 0 {
+		i++
+	}
+	return 1 + bytes.Count(data[:i], []byte("\n"))
+}
+
+func jsonError(data []byte, dec *json.Decoder, err error) error {
+	offset := dec.InputOffset()
+	switch e := err.(type) {
+	case *json.SyntaxError:
+		offset = e.Offset
+	case *json.UnmarshalTypeError:
+		offset = e.Offset
+	}
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		err = fmt.Errorf("unexpected end of JSON input")
+	}
+
+	return &lineError{jsonLine(data, offset), "Invalid JSON: " + err.Error()}
+}
+
+// YAML
+
+// yamlItem is the position of a record of a YAML config
+type yamlItem struct {
+	line int
+	keys map[string]int
+}
+
+var yamlLineRegexp = regexp.MustCompile(`line (\d+): (.*)`)
+
+// parseYAMLConfig parses a YAML config, records being either grouped by zone
+// in a mapping or listed in a sequence
+func parseYAMLConfig(data []byte) ([]rawRecord, []configInclude, error) {
+	var doc interface{}
+	err := yaml.Unmarshal(data, &doc)
+	if err != nil {
+		return nil, nil, yamlError(err)
+	}
+
+	zoneLines, items, includeLines := yamlPositions(data)
+
+	var raws []rawRecord
+	var includes []configInclude
+	switch doc.(type) {
+	case nil:
+		return nil, nil, nil
+	case []interface{}:
+		raws, err = yamlRecords("", doc.([]interface{}), 1)
+		if err != nil {
+			return nil, nil, err
+		}
+	case map[interface{}]interface{}:
+		var zones yaml.MapSlice
+		err = yaml.Unmarshal(data, &zones)
+		if err != nil {
+			return nil, nil, yamlError(err)
+		}
+		for _, z := range zones {
+			zone := fmt.Sprint(z.Key)
+			list, ok := z.Value.([]interface{})
+			if !ok {
+				if zone == includeKey {
+					return nil, nil, &lineError{zoneLines[zone], "Includes must be a sequence of paths"}
+				}
+				return nil, nil, &lineError{zoneLines[zone], fmt.Sprintf("Records of zone `%s` must be a sequence", zone)}
+			}
+
+			if zone == includeKey {
+				for i, item := range list {
+					line := zoneLines[zone]
+					if len(includeLines) == len(list) {
+						line = includeLines[i]
+					}
+					path, ok := item.(string)
+					if !ok {
+						return nil, nil, &lineError{line, fmt.Sprintf("Invalid include `%v`, expecting a path", item)}
+					}
+					includes = append(includes, configInclude{path, line})
+				}
+				continue
+			}
+
+			records, err := yamlRecords(zone, list, zoneLines[zone])
+			if err != nil {
+				return nil, nil, err
+			}
+			raws = append(raws, records...)
+		}
+	default:
+		return nil, nil, &lineError{1, "Records must be grouped by zone in a mapping or listed in a sequence"}
+	}
+
+	// The positions are only known when the records are written in block style
+	if len(items) == len(raws) {
+		for i := range raws {
+			raws[i].line = items[i].line
+			for key := range raws[i].fields {
+				raws[i].lines[key] = items[i].keys[key]
+			}
+		}
+	}
+
+	return raws, includes, nil
+}
+
+// yamlRecords reads the records of a sequence, mappings being decoded
+// as map slices in a config grouped by zone
+func yamlRecords(zone string, list []interface{}, line int) ([]rawRecord, error) {
+	raws := []rawRecord{}
+	for _, item := range list {
+		var m yaml.MapSlice
+		switch v := item.(type) {
+		case yaml.MapSlice:
+			m = v
+		case map[interface{}]interface{}:
+			for k, value := range v {
+				m = append(m, yaml.MapItem{Key: k, Value: value})
+			}
+		default:
+			raws = append(raws, rawRecord{zone: zone, line: line})
+			continue
+		}
+
+		raw := newRawRecord(zone, line)
+		for _, field := range m {
+			key, ok := field.Key.(string)
+			if !ok {
+				return nil, &lineError{line, fmt.Sprintf("Invalid field `%v`", field.Key)}
+			}
+			raw.fields[key] = field.Value
+		}
+		raws = append(raws, raw)
+	}
+	return raws, nil
+}
+
+// yamlPositions finds the lines of the zones, of the records and of the
+// includes of a YAML config written in block style: the items of the
+// sequences and their keys
+func yamlPositions(data []byte) (map[string]int, []yamlItem, []int) {
+	zones := map[string]int{}
+	items := []yamlItem{}
+	includes := []int{}
+
+	var item *yamlItem
+	zone := ""
+	for i, line := range strings.Split(string(data), "\n") {
+		n := i + 1
+		text := strings.TrimSpace(line)
+		if text == "" || text[0] == '#' || text == "---" || text == "..." {
+			continue
+		}
+
+		indent := len(line) - len(strings.TrimLeft(line, " "))
+		if indent == 0 && text[0] != '-' {
+			zone = yamlKey(text)
+			zones[zone] = n
+			item = nil
+			continue
+		}
+
+		if zone == includeKey {
+			if strings.HasPrefix(text, "- ") {
+				includes = append(includes, n)
+			}
+			continue
+		}
+
+		if text == "-" || strings.HasPrefix(text, "- ") {
+			items = append(items, yamlItem{line: n, keys: map[string]int{}})
+			item = &items[len(items)-1]
+			text = strings.TrimSpace(text[1:])
+		}
+
+		if key := yamlKey(text); item != nil && key != "" && item.keys[key] == 0 {
+			item.keys[key] = n
+		}
+	}
+
+	return zones, items, includes
+}
+
+// yamlKey returns the key of a line of a mapping
+func yamlKey(text string) string {
+	i := strings.Index(text, ":")
+	if i <= 0 {
+		return ""
+	}
+	key := strings.TrimSpace(text[:i])
+	if len(key) >= 2 && (key[0] == '"' || key[0] == '\'') && key[len(key)-1] == key[0] {
+		key = key[1 : len(key)-1]
+	}
+	return key
+}
+
+func yamlError(err error) error {
+	m := yamlLineRegexp.FindStringSubmatch(err.Error())
+	if m == nil {
+		return &lineError{0, "Invalid YAML: " + strings.TrimPrefix(err.Error(), "yaml: ")}
+	}
+
+	line, _ := strconv.Atoi(m[1])
+	return &lineError{line, "Invalid YAML: " + m[2]}
+}
+
+// TOML
+
+var tomlPositionRegexp = regexp.MustCompile(`^\((\d+), \d+\): (.*)`)
+
+// parseTOMLConfig parses a TOML config, records being arrays of tables named by zone
+func parseTOMLConfig(data []byte) ([]rawRecord, []configInclude, error) {
+	tree, err := toml.Load(string(data))
+	if err != nil {
+		m := tomlPositionRegexp.FindStringSubmatch(err.Error())
+		if m == nil {
+			return nil, nil, &lineError{0, "Invalid TOML: " + err.Error()}
+		}
+		line, _ := strconv.Atoi(m[1])
+		return nil, nil, &lineError{line, "Invalid TOML: " + m[2]}
+	}
+
+	raws := []rawRecord{}
+	var includes []configInclude
+	for _, zone := range tree.Keys() {
+		if zone == includeKey {
+			line := tree.GetPositionPath([]string{zone}).Line
+			paths, ok := tree.GetPath([]string{zone}).([]interface{})
+			if !ok {
+				return nil, nil, &lineError{line, "Includes must be an array of paths"}
+			}
+			for _, p := range paths {
+				path, ok := p.(string)
+				if !ok {
+					return nil, nil, &lineError{line, fmt.Sprintf("Invalid include `%v`, expecting a path", p)}
+				}
+				includes = append(includes, configInclude{path, line})
+			}
+			continue
+		}
+
+		tables, ok := tree.GetPath([]string{zone}).([]*toml.TomlTree)
+		if !ok {
+			line := tree.GetPositionPath([]string{zone}).Line
+			return nil, nil, &lineError{line, fmt.Sprintf("Records of zone `%s` must be an array of tables [[\"%s\"]]", zone, zone)}
+		}
+
+		for _, table := range tables {
+			raw := newRawRecord(zone, table.GetPosition("").Line)
+			for _, key := range table.Keys() {
+				raw.fields[key] = table.GetPath([]string{key})
+				raw.lines[key] = table.GetPositionPath([]string{key}).Line
+			}
+			raws = append(raws, raw)
+		}
+	}
+
+	sort.SliceStable(raws, func(i, j int) bool { return raws[i].line < raws[j].line })
+
+	return raws, includes, nil
+}
+
+// encodeTOMLConfig encodes the includes and records in arrays of tables named by zone
+func encodeTOMLConfig(records []Record, includes []string) []byte {
+	var buf bytes.Buffer
+	writeIncludes(&buf, includes)
+	for i, r := range ungroupRecords(groupRecords(records)) {
+		if i > 0 {
+			buf.WriteString("\n")
+		}
+		fmt.Fprintf(&buf, "[[%s]]\n", quote(r.Zone))
+		writeFields(&buf, r)
+	}
+	return buf.Bytes()
+}
+
+// writeFields writes the fields of a record, one by line
+func writeFields(buf *bytes.Buffer, r Record) {
+	fmt.Fprintf(buf, "subDomain = %s\n", quote(r.SubDomain))
+	fmt.Fprintf(buf, "target = %s\n", quote(r.Target))
+	if r.ID != 0 {
+		fmt.Fprintf(buf, "id = %d\n", r.ID)
+	}
+	if r.TTL != 0 {
+		fmt.Fprintf(buf, "ttl = %d\n", r.TTL)
+	}
+	if r.FieldType != "" {
+		fmt.Fprintf(buf, "fieldType = %s\n", quote(r.FieldType))
+	}
+}
+
+// quote quotes a string with the escape sequences of JSON, which TOML shares
+func quote(s string) string {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	enc.Encode(s)
+	return strings.TrimSuffix(buf.String(), "\n")
+}
@@ -0,0 +1,114 @@
+package client
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/user"
+	"syscall"
+	"time"
+)
+
+// LockInfo describes the holder of a lock
+type LockInfo struct {
+	ID        string    `json:"id"`
+	Operation string    `json:"operation"`
+	Who       string    `json:"who"`
+	Host      string    `json:"host"`
+	PID       int       `json:"pid"`
+	Created   time.Time `json:"created"`
+}
+
+// Lock is a lock of the state preventing concurrent modifications
+type Lock struct {
+	backend StateBackend
+	Info    LockInfo
+}
+
+// AcquireLock locks the state of a backend for an operation. A lock held by
+// a process that is no longer running on this host, or a lock that can not be
+// decoded, is stale and taken over.
+func AcquireLock(backend StateBackend, operation string) (*Lock, error) {
+	info, err := newLockInfo(operation)
+	if err != nil {
+		return nil, err
+	}
+
+	err = backend.Lock(info)
+	if lockedErr, ok := err.(*LockedError); ok && lockedErr.stale() {
+		id := ""
+		if lockedErr.Holder != nil {
+			id = lockedErr.Holder.ID
+		}
+		err = backend.Unlock(id)
+		if err != nil {
+			return nil, err
+		}
+		err = backend.Lock(info)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &Lock{backend: backend, Info: *info}, nil
+}
+
+// Release unlocks the state
+func (l *Lock) Release() error {
+	return l.backend.Unlock(l.Info.ID)
+}
+
+func newLockInfo(operation string) (*LockInfo, error) {
+	id := make([]byte, 16)
+	_, err := rand.Read(id)
+	if err != nil {
+		return nil, err
+	}
+
+	host, _ := os.Hostname()
+
+	who := "unknown"
+	if u, err := user.Current(); err == nil {
+		who = u.Username
+	}
+
+	return &LockInfo{
+		ID:        fmt.Sprintf("%x-%x-%x-%x-%x", id[0:4], id[4:6], id[6:8], id[8:10], id[10:]),
+		Operation: operation,
+		Who:       who + "@" + host,
+		Host:      host,
+		PID:       os.Getpid(),
+		Created:   time.Now().UTC(),
+	}, nil
+}
+
+func decodeLockInfo(data []byte) (*LockInfo, error) {
+	info := &LockInfo{}
+	err := json.Unmarshal(data, info)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid lock: %s", err)
+	}
+
+	return info, nil
+}
+
+func (i *LockInfo) encode() ([]byte, error) {
+	return json.MarshalIndent(i, "", "  ")
+}
+
+// stale returns true if the lock is held by a process no longer running on this host
+func (i *LockInfo) stale() bool {
+	host, err := os.Hostname()
+	if err != nil || host != i.Host {
+		return false
+	}
+
+	process, err := os.FindProcess(i.PID)
+	if err != nil {
+		return true
+	}
+
+	err = process.Signal(syscall.Signal(0))
+	return err != nil && err != syscall.EPERM
+}
@@ -0,0 +1,146 @@
+package client
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strings"
+)
+
+// Plan represents the modifications to apply on a DNS zone
+type Plan struct {
+	Zone        string `json:"zone" yaml:"zone"`
+	Fingerprint string `json:"fingerprint" yaml:"fingerprint"`
+	// Unrefreshed is true if the plan was computed against the cached records
+	// or the state instead of the records of the DNS zone: it can not be applied
+	Unrefreshed bool     `json:"unrefreshed,omitempty" yaml:"unrefreshed,omitempty"`
+	ToAdd       []Record `json:"toAdd" yaml:"toAdd"`
+	ToUpdate    []Update `json:"toUpdate" yaml:"toUpdate"`
+	ToRm        []Record `json:"toRm" yaml:"toRm"`
+}
+
+// HasChanges returns true if the plan modifies the DNS zone
+func (p *Plan) HasChanges() bool {
+	return len(p.ToAdd)+len(p.ToUpdate)+len(p.ToRm) > 0
+}
+
+// Actions of the changes applied on a DNS zone
+const (
+	Added   = "added"
+	Updated = "updated"
+	Removed = "removed"
+)
+
+// Change represents a modification applied on a DNS zone record
+type Change struct {
+	Action string `json:"action" yaml:"action"`
+	Record Record `json:"record" yaml:"record"`
+}
+
+// Update represents an in-place modification of a DNS zone record
+type Update struct {
+	From Record `json:"from" yaml:"from"`
+	To   Record `json:"to" yaml:"to"`
+}
+
+// Changes describes the attributes modified by the update
+func (u Update) Changes() string {
+	changes := []string{}
+	if u.From.Target != u.To.Target {
+		changes = append(changes, fmt.Sprintf("target: %s => %s", u.From.Target, u.To.Target))
+	}
+	if u.From.TTL != u.To.TTL {
+		changes = append(changes, fmt.Sprintf("ttl: %d => %d", u.From.TTL, u.To.TTL))
+	}
+	return strings.Join(changes, ", ")
+}
+
+// planUpdates turns each pair of a record to add and a record to remove
+// with the same zone, type and sub domain into an update of the existing record
+func planUpdates(toAdd []Record, toUpdate []Update, toRm []Record) ([]Record, []Update, []Record) {
+	var adds []Record
+
+	for _, a := range toAdd {
+		paired := false
+		for i, r := range toRm {
+			if r.ID != 0 && r.Zone == a.Zone && r.Type() == a.Type() && r.SubDomain == a.SubDomain {
+				to := a
+				to.ID = r.ID
+				toUpdate = append(toUpdate, Update{From: r, To: to})
+				toRm = append(toRm[:i], toRm[i+1:]...)
+				paired = true
+				break
+			}
+		}
+		if !paired {
+			adds = append(adds, a)
+		}
+	}
+
+	return adds, toUpdate, toRm
+}
+
+// fingerprint computes a hash of the DNS zone records used to detect
+// changes of the DNS zone between a plan and its apply
+func fingerprint(records Records) string {
+	lines := make([]string, len(records))
+	for i, r := range records {
+		lines[i] = fmt.Sprintf("%d %s %s %s %d", r.ID, r.Type(), r.SubDomain, r.Target, r.TTL)
+	}
+	sort.Strings(lines)
+
+	return fmt.Sprintf("%x", sha256.Sum256([]byte(strings.Join(lines, "\n"))))
+}
+
+// LoadPlans loads the plans of one or several zones from a file in JSON format
+func LoadPlans(filepath string) ([]*Plan, error) {
+	data, err := ioutil.ReadFile(filepath)
+	if err != nil {
+		return nil, err
+	}
+
+	var plans []*Plan
+	if err := json.Unmarshal(data, &plans); err != nil {
+		return nil, err
+	}
+
+	for _, plan := range plans {
+		if plan.Zone == "" || plan.Fingerprint == "" {
+			return nil, fmt.Errorf("Invalid plan file `%s`", filepath)
+		}
+	}
+
+	return plans, nil
+}
+
+// SavePlans saves the plans of one or several zones in a file in JSON format
+func SavePlans(filepath string, plans []*Plan) error {
+	// Plans without changes are saved with empty lists instead of null
+	saved := make([]Plan, len(plans))
+	for i, p := range plans {
+		saved[i] = *p
+		if saved[i].ToAdd == nil {
+			saved[i].ToAdd = []Record{}
+		}
+		if saved[i].ToUpdate == nil {
+			saved[i].ToUpdate = []Update{}
+		}
+		if saved[i].ToRm == nil {
+			saved[i].ToRm = []Record{}
+		}
+	}
+
+	data, err := json.MarshalIndent(saved, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	err = ioutil.WriteFile(filepath, data, 0644)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,118 @@
+package client
+
+import "sort"
+
+// Provider manages the records of DNS zones
+type Provider interface {
+	// List lists all records of a zone
+	List(zone string) (Records, error)
+	// Get gets a record of a zone given its ID
+	Get(zone string, id int64) (*Record, error)
+	// Create creates a record in a zone and returns it with its ID
+	Create(zone string, record Record) (*Record, error)
+	// Update updates a record of a zone given its ID
+	Update(zone string, record Record) error
+	// Delete deletes a record of a zone given its ID
+	Delete(zone string, id int64) error
+	// Refresh commits the modifications of a zone to the DNS servers
+	Refresh(zone string) error
+}
+
+// DefaultTTLProvider is a provider giving a default TTL to the records created
+// without TTL (TTL 0), their TTL being the default TTL once listed
+type DefaultTTLProvider interface {
+	DefaultTTL() int
+}
+
+// TargetNormalizer is a provider listing the targets of the records in a
+// normalized form, which can differ from the configured targets
+type TargetNormalizer interface {
+	NormalizeTarget(record Record) string
+}
+
+// normalizeTargets returns records with the targets normalized as the provider lists them
+func (c *OnsClient) normalizeTargets(records []Record) []Record {
+	if c.normalizer == nil {
+		return records
+	}
+
+	normalized := make([]Record, len(records))
+	for i, r := range records {
+		r.Target = c.normalizer.NormalizeTarget(r)
+		normalized[i] = r
+	}
+	return normalized
+}
+
+// ListRecords lists all DNS zone records of the types managed by ons
+func (c *OnsClient) ListRecords(zone string) (Records, error) {
+	return c.listRecords(zone, c.live)
+}
+
+// listRecords lists all DNS zone records of the types managed by ons.
+// If live is true, the records are listed from the DNS provider, skipping
+// the cache, whatever the refresh mode.
+func (c *OnsClient) listRecords(zone string, live bool) (Records, error) {
+	var records Records
+	var err error
+
+	switch {
+	case live:
+		if c.cache != nil {
+			c.cache.invalidate(zone)
+		}
+		records, err = c.provider.List(zone)
+	case c.refresh:
+		records, err = c.provider.List(zone)
+	default:
+		records = c.knownRecords(zone)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	supportedRecords := Records{}
+	for _, r := range records {
+		if IsSupportedFieldType(r.Type()) {
+			supportedRecords = append(supportedRecords, r)
+		}
+	}
+
+	sort.Sort(supportedRecords)
+
+	return supportedRecords, nil
+}
+
+// knownRecords returns the records of a DNS zone without listing them:
+// the cached records, even expired, or the records of the state
+func (c *OnsClient) knownRecords(zone string) Records {
+	if c.cache != nil {
+		if records, ok := c.cache.get(zone, false); ok {
+			return records
+		}
+	}
+
+	return Records(c.state.zoneRecords(zone))
+}
+
+// dryRunProvider is a provider that never modifies the DNS zones
+type dryRunProvider struct {
+	Provider
+}
+
+func (p dryRunProvider) Create(zone string, record Record) (*Record, error) {
+	record.Zone = zone
+	return &record, nil
+}
+
+func (p dryRunProvider) Update(zone string, record Record) error {
+	return nil
+}
+
+func (p dryRunProvider) Delete(zone string, id int64) error {
+	return nil
+}
+
+func (p dryRunProvider) Refresh(zone string) error {
+	return nil
+}
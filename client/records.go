@@ -1,29 +1,67 @@
 package client
 
 import (
+	"bytes"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
 )
 
 // Record represents a DNS zone record
 type Record struct {
-	Zone      string `json:"zone"`
-	SubDomain string `json:"subDomain"`
-	Target    string `json:"target"`
+	Zone      string `json:"zone,omitempty" yaml:"zone,omitempty"`
+	SubDomain string `json:"subDomain" yaml:"subDomain"`
+	Target    string `json:"target" yaml:"target"`
 
-	ID        int64  `json:"id,omitempty"`
-	TTL       int    `json:"ttl,omitempty"`
-	FieldType string `json:"fieldType,omitempty"`
+	ID        int64  `json:"id,omitempty" yaml:"id,omitempty"`
+	TTL       int    `json:"ttl,omitempty" yaml:"ttl,omitempty"`
+	FieldType string `json:"fieldType,omitempty" yaml:"fieldType,omitempty"`
 
-	Managed string `json:"-"`
+	Managed string `json:"-" yaml:"-"`
+	// Source is the file and the line of a configured record
+	Source string `json:"-" yaml:"-"`
+}
+
+// FieldTypes lists the DNS zone record types managed by ons
+var FieldTypes = []string{"A", "AAAA", "CNAME", "MX", "TXT", "SRV", "NS", "CAA"}
+
+// DefaultFieldType is the type of a record when none is configured
+const DefaultFieldType = "A"
+
+// IsSupportedFieldType returns true if the record type is managed by ons
+func IsSupportedFieldType(fieldType string) bool {
+	for _, t := range FieldTypes {
+		if t == fieldType {
+			return true
+		}
+	}
+	return false
+}
+
+// Type returns the record type, A by default
+func (r Record) Type() string {
+	if r.FieldType == "" {
+		return DefaultFieldType
+	}
+	return r.FieldType
+}
+
+// Name returns the fully qualified domain name of the record
+func (r Record) Name() string {
+	if r.SubDomain == "" {
+		return r.Zone
+	}
+	return r.SubDomain + "." + r.Zone
 }
 
 // GetBySubDomainAndTarget gets a record from a list of records  by comparing records
-// zone, sub domain and target
+// zone, type, sub domain and target
 func (r Record) GetBySubDomainAndTarget(records Records) *Record {
 	for _, re := range records {
-		if re.Zone == r.Zone && re.SubDomain == r.SubDomain && re.Target == r.Target {
+		if re.Zone == r.Zone && re.Type() == r.Type() && re.SubDomain == r.SubDomain && re.Target == r.Target {
 			return &re
 		}
 	}
@@ -42,7 +80,7 @@ func (r Record) ExistsInBySubDomain(records Records) bool {
 }
 
 // ExistsInBySubDomainAndTarget returns true if a record exists in a list of records
-// by comparing records zone, sub domain and target
+// by comparing records zone, type, sub domain and target
 func (r Record) ExistsInBySubDomainAndTarget(records Records) bool {
 	record := r.GetBySubDomainAndTarget(records)
 	return record != nil
@@ -61,42 +99,132 @@ func (r Record) ExistsInByID(records Records) bool {
 
 // Print prints a record with fixed indentation and colors
 func (r Record) Print() {
-	fmt.Printf("%-30s %-1s %s\n", magenta(r.Target), r.Managed, green(r.SubDomain+"."+r.Zone))
+	fmt.Printf("%-5s %-30s %-1s %s\n", r.Type(), magenta(r.Target), r.Managed, green(r.Name()))
 }
 
 // Records represents a list of DNS zone record
 type Records []Record
 
-// loadRecords loads records from a file in JSON format
-func loadRecords(filepath string) ([]Record, error) {
-	data, err := ioutil.ReadFile(filepath)
-	if err != nil {
-		return nil, err
+// decodeRecords decodes records in JSON format. Records are either
+// grouped by zone in an object or listed in an array.
+func decodeRecords(data []byte) ([]Record, error) {
+	var records []Record
+
+	data = bytes.TrimSpace(data)
+	if len(data) > 0 && data[0] == '[' {
+		if err := json.Unmarshal(data, &records); err != nil {
+			return nil, err
+		}
+	} else if len(data) > 0 {
+		var zones map[string][]Record
+		if err := json.Unmarshal(data, &zones); err != nil {
+			return nil, err
+		}
+		records = ungroupRecords(zones)
 	}
 
-	var records []Record
-	if err := json.Unmarshal(data, &records); err != nil {
-		return nil, err
+	for i, r := range records {
+		if r.FieldType == "" {
+			records[i].FieldType = DefaultFieldType
+		}
 	}
 
 	return records, nil
 }
 
-// saveRecords loads records in a file in JSON format
-func saveRecords(filepath string, records []Record) error {
-	data, err := json.MarshalIndent(records, "", "  ")
+// encodeRecords encodes records grouped by zone in JSON format
+func encodeRecords(records []Record) ([]byte, error) {
+	return json.MarshalIndent(groupRecords(records), "", "  ")
+}
+
+// groupRecords groups records by zone, the zone of the records being removed
+func groupRecords(records []Record) map[string][]Record {
+	zones := map[string][]Record{}
+	for _, r := range records {
+		zone := r.Zone
+		r.Zone = ""
+		zones[zone] = append(zones[zone], r)
+	}
+	return zones
+}
+
+// ungroupRecords lists records grouped by zone, sorted by zone
+func ungroupRecords(zones map[string][]Record) []Record {
+	names := []string{}
+	for zone := range zones {
+		names = append(names, zone)
+	}
+	sort.Strings(names)
+
+	var records []Record
+	for _, zone := range names {
+		for _, r := range zones[zone] {
+			r.Zone = zone
+			records = append(records, r)
+		}
+	}
+	return records
+}
+
+// writeFile writes a file atomically by writing a temporary file
+// in the same directory and renaming it
+func writeFile(path string, data []byte, perm os.FileMode) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path)+".*")
 	if err != nil {
 		return err
 	}
 
-	err = ioutil.WriteFile(filepath, data, 0644)
+	_, err = tmp.Write(data)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chmod(tmp.Name(), perm)
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), path)
+	}
 	if err != nil {
+		os.Remove(tmp.Name())
 		return err
 	}
 
 	return nil
 }
 
+// recordsInZone returns the records of a zone
+func recordsInZone(records []Record, zone string) []Record {
+	zoneRecords := []Record{}
+	for _, r := range records {
+		if r.Zone == zone {
+			zoneRecords = append(zoneRecords, r)
+		}
+	}
+	return zoneRecords
+}
+
+// zonesOf lists the zones of records
+func zonesOf(records []Record) []string {
+	zones := []string{}
+	for _, r := range records {
+		found := false
+		for _, z := range zones {
+			if z == r.Zone {
+				found = true
+				break
+			}
+		}
+		if !found {
+			zones = append(zones, r.Zone)
+		}
+	}
+	sort.Strings(zones)
+	return zones
+}
+
 // Records is sortable
 
 func (r Records) Len() int {
@@ -108,5 +236,11 @@ func (r Records) Swap(i, j int) {
 }
 
 func (r Records) Less(i, j int) bool {
-	return r[i].SubDomain < r[j].SubDomain
+	if r[i].SubDomain != r[j].SubDomain {
+		return r[i].SubDomain < r[j].SubDomain
+	}
+	if r[i].Type() != r[j].Type() {
+		return r[i].Type() < r[j].Type()
+	}
+	return r[i].Target < r[j].Target
 }
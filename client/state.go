@@ -1,31 +1,257 @@
 package client
 
-import "os"
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+)
+
+// DefaultStateHistory is the number of snapshots of the state kept by default
+const DefaultStateHistory = 100
+
+// snapshotsIndexName is the name of the index of the snapshots in a state backend
+const snapshotsIndexName = "snapshots/index.json"
 
 // DNSState represents a DNS zone configuration
 type DNSState struct {
-	statePath string
-	records   []Record
+	backend StateBackend
+	records []Record
+
+	// serial is incremented at each write of the state
+	serial int64
+	// lineage identifies a state from its creation
+	lineage string
+
+	history   int
+	snapshots []Snapshot
 }
 
-func loadState(statePath string) (*DNSState, error) {
+// stateFile represents the state with its version
+type stateFile struct {
+	Serial  int64               `json:"serial"`
+	Lineage string              `json:"lineage"`
+	Records map[string][]Record `json:"records"`
+}
 
-	if _, err := os.Stat(statePath); os.IsNotExist(err) {
-		records := []Record{}
-		saveRecords(statePath, records)
+// Snapshot describes a version of the state kept in the state backend
+type Snapshot struct {
+	Serial  int64     `json:"serial"`
+	Lineage string    `json:"lineage"`
+	Created time.Time `json:"created"`
+	Records int       `json:"records"`
+}
+
+func loadState(backend StateBackend) (*DNSState, error) {
+	data, err := backend.Get(StateName)
+	if err != nil {
+		return nil, err
 	}
 
-	records, err := loadRecords(statePath)
+	state, err := decodeState(data)
 	if err != nil {
 		return nil, err
 	}
+	state.backend = backend
+	state.history = DefaultStateHistory
+
+	if data == nil {
+		err = state.save()
+		if err != nil {
+			return nil, err
+		}
+		err = state.snapshot()
+		if err != nil {
+			return nil, err
+		}
+	}
 
-	return &DNSState{
-		statePath: statePath,
-		records:   records,
-	}, nil
+	return state, nil
 }
 
+// decodeState decodes a state. A state without serial and lineage is
+// a state in the format of the config, written by a former version of ons.
+func decodeState(data []byte) (*DNSState, error) {
+	state := &DNSState{}
+
+	var file stateFile
+	if bytes.HasPrefix(bytes.TrimSpace(data), []byte("{")) &&
+		json.Unmarshal(data, &file) == nil && file.Lineage != "" {
+		state.serial = file.Serial
+		state.lineage = file.Lineage
+		state.records = ungroupRecords(file.Records)
+	} else {
+		records, err := decodeRecords(data)
+		if err != nil {
+			return nil, err
+		}
+		state.records = records
+	}
+
+	if state.records == nil {
+		state.records = []Record{}
+	}
+
+	return state, nil
+}
+
+// save saves a new version of the state. The state is saved at each modification,
+// a snapshot is kept only once per command with snapshot.
 func (s *DNSState) save() error {
-	return saveRecords(s.statePath, s.records)
+	if s.lineage == "" {
+		lineage, err := newLineage()
+		if err != nil {
+			return err
+		}
+		s.lineage = lineage
+	}
+	s.serial++
+
+	data, err := s.encode()
+	if err != nil {
+		return err
+	}
+
+	return s.backend.Put(StateName, data)
+}
+
+func (s *DNSState) encode() ([]byte, error) {
+	return json.MarshalIndent(stateFile{
+		Serial:  s.serial,
+		Lineage: s.lineage,
+		Records: groupRecords(s.records),
+	}, "", "  ")
+}
+
+// snapshot keeps a snapshot of the current version of the state, if not already
+// kept, and removes the oldest snapshots
+func (s *DNSState) snapshot() error {
+	if s.history <= 0 {
+		return nil
+	}
+
+	snapshots, err := s.listSnapshots()
+	if err != nil {
+		return err
+	}
+	if len(snapshots) > 0 && snapshots[len(snapshots)-1].Serial == s.serial {
+		return nil
+	}
+
+	data, err := s.encode()
+	if err != nil {
+		return err
+	}
+
+	err = s.backend.Put(snapshotName(s.serial), data)
+	if err != nil {
+		return err
+	}
+
+	snapshots = append(snapshots, Snapshot{
+		Serial:  s.serial,
+		Lineage: s.lineage,
+		Created: time.Now().UTC(),
+		Records: len(s.records),
+	})
+
+	for len(snapshots) > s.history {
+		err = s.backend.Delete(snapshotName(snapshots[0].Serial))
+		if err != nil {
+			return err
+		}
+		snapshots = snapshots[1:]
+	}
+
+	index, err := json.MarshalIndent(snapshots, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	err = s.backend.Put(snapshotsIndexName, index)
+	if err != nil {
+		return err
+	}
+
+	s.snapshots = snapshots
+
+	return nil
+}
+
+// listSnapshots lists the snapshots of the state sorted by serial
+func (s *DNSState) listSnapshots() ([]Snapshot, error) {
+	if s.snapshots != nil {
+		return s.snapshots, nil
+	}
+
+	data, err := s.backend.Get(snapshotsIndexName)
+	if err != nil {
+		return nil, err
+	}
+
+	snapshots := []Snapshot{}
+	if data != nil {
+		err = json.Unmarshal(data, &snapshots)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid index of the state snapshots: %s", err)
+		}
+	}
+
+	sort.Slice(snapshots, func(i, j int) bool { return snapshots[i].Serial < snapshots[j].Serial })
+	s.snapshots = snapshots
+
+	return snapshots, nil
+}
+
+// loadSnapshot loads the records of a snapshot given its serial
+func (s *DNSState) loadSnapshot(serial int64) ([]Record, error) {
+	data, err := s.backend.Get(snapshotName(serial))
+	if err != nil {
+		return nil, err
+	}
+	if data == nil {
+		return nil, fmt.Errorf("No snapshot of the state with serial %d", serial)
+	}
+
+	snapshot, err := decodeState(data)
+	if err != nil {
+		return nil, err
+	}
+	if snapshot.lineage != s.lineage {
+		return nil, fmt.Errorf("Snapshot %d belongs to another state (lineage %s)", serial, snapshot.lineage)
+	}
+
+	return snapshot.records, nil
+}
+
+// zoneRecords returns the records of a zone in the state
+func (s *DNSState) zoneRecords(zone string) []Record {
+	return recordsInZone(s.records, zone)
+}
+
+// setZoneRecords replaces the records of a zone in the state
+func (s *DNSState) setZoneRecords(zone string, records []Record) {
+	newRecords := []Record{}
+	for _, r := range s.records {
+		if r.Zone != zone {
+			newRecords = append(newRecords, r)
+		}
+	}
+	s.records = append(newRecords, records...)
+}
+
+func snapshotName(serial int64) string {
+	return fmt.Sprintf("snapshots/%d.json", serial)
+}
+
+func newLineage() (string, error) {
+	b := make([]byte, 16)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:]), nil
 }
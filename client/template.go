@@ -0,0 +1,133 @@
+package client
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"strconv"
+	"strings"
+	"text/template"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+// Vars are the variables of a templated config
+type Vars map[string]interface{}
+
+// LoadVarsFile loads variables from a file in JSON or YAML
+func LoadVarsFile(path string) (Vars, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	vars := Vars{}
+	err = yaml.Unmarshal(data, &vars)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid variables file `%s`: %s", path, strings.TrimPrefix(err.Error(), "yaml: "))
+	}
+
+	return vars, nil
+}
+
+// templateFuncs are the functions of a templated config
+var templateFuncs = template.FuncMap{
+	"seq":   seq,
+	"add":   add,
+	"split": strings.Split,
+	"lower": strings.ToLower,
+	"upper": strings.ToUpper,
+}
+
+// ExpandConfig returns a config expanded as a template with variables
+func ExpandConfig(configPath string, vars Vars) ([]byte, error) {
+	data, err := ioutil.ReadFile(configPath)
+	if err != nil {
+		return nil, err
+	}
+
+	return expandConfig(configPath, data, vars)
+}
+
+// expandConfig expands a config as a Go text/template given variables,
+// a missing variable being an error
+func expandConfig(path string, data []byte, vars Vars) ([]byte, error) {
+	if !bytes.Contains(data, []byte("{{")) {
+		return data, nil
+	}
+
+	tmpl, err := template.New(path).Funcs(templateFuncs).Option("missingkey=error").Parse(string(data))
+	if err != nil {
+		return nil, err
+	}
+
+	if vars == nil {
+		vars = Vars{}
+	}
+
+	var buf bytes.Buffer
+	err = tmpl.Execute(&buf, map[string]interface{}(vars))
+	if err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// seq returns the integers from 1 to n, or from first to last
+func seq(bounds ...interface{}) ([]int, error) {
+	if len(bounds) == 0 || len(bounds) > 2 {
+		return nil, fmt.Errorf("seq requires 1 or 2 arguments")
+	}
+
+	ints := []int{}
+	for _, b := range bounds {
+		i, err := toTemplateInt(b)
+		if err != nil {
+			return nil, err
+		}
+		ints = append(ints, i)
+	}
+
+	first, last := 1, ints[0]
+	if len(ints) == 2 {
+		first, last = ints[0], ints[1]
+	}
+
+	s := []int{}
+	for i := first; i <= last; i++ {
+		s = append(s, i)
+	}
+	return s, nil
+}
+
+// add returns the sum of integers
+func add(values ...interface{}) (int, error) {
+	sum := 0
+	for _, v := range values {
+		i, err := toTemplateInt(v)
+		if err != nil {
+			return 0, err
+		}
+		sum += i
+	}
+	return sum, nil
+}
+
+// toTemplateInt converts an integer or a string of an integer, variables
+// set by flags or environment variables being strings
+func toTemplateInt(value interface{}) (int, error) {
+	if s, ok := value.(string); ok {
+		i, err := strconv.Atoi(s)
+		if err != nil {
+			return 0, fmt.Errorf("`%s` is not an integer", s)
+		}
+		return i, nil
+	}
+
+	i, ok := toInt(value)
+	if !ok {
+		return 0, fmt.Errorf("`%v` is not an integer", value)
+	}
+	return int(i), nil
+}
@@ -0,0 +1,246 @@
+package client
+
+import (
+	"fmt"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// TTL bounds of a record, a TTL of 0 being the default TTL of the zone
+const (
+	MinTTL = 60
+	MaxTTL = 2147483647
+)
+
+var (
+	labelRegexp  = regexp.MustCompile(`^[A-Za-z0-9_]([A-Za-z0-9_-]{0,61}[A-Za-z0-9_])?$`)
+	caaTagRegexp = regexp.MustCompile(`^[A-Za-z0-9]+$`)
+)
+
+// ValidateConfig loads a config and checks its records without a DNS provider
+// nor a state: the syntax of the config and of the records, the duplicate
+// records, the CNAME records coexisting with other records and the records
+// outside of their zone. It returns the records of a valid config, or a
+// *ConfigError listing every problem found.
+func ValidateConfig(configPath string, defaultZone string, vars Vars) ([]Record, error) {
+	config, err := readConfig(configPath, vars)
+	if err != nil {
+		return nil, err
+	}
+	records := config.allRecords()
+
+	errs := setDefaultZone(records, defaultZone)
+	if len(errs) > 0 {
+		return nil, &ConfigError{Errors: errs}
+	}
+
+	errs = checkRecords(records)
+	if len(errs) > 0 {
+		return nil, &ConfigError{Errors: errs}
+	}
+
+	return records, nil
+}
+
+// checkDuplicates reports the records defined twice, in the same file or not
+func checkDuplicates(records []Record) []string {
+	errs := []string{}
+	for i, r := range records {
+		for _, o := range records[:i] {
+			if o.Zone == r.Zone && o.Type() == r.Type() && strings.EqualFold(o.SubDomain, r.SubDomain) && o.Target == r.Target {
+				errs = append(errs, fmt.Sprintf("%s: Duplicate record `%s %s %s`, already defined at %s",
+					r.Source, r.Name(), r.Type(), r.Target, o.Source))
+				break
+			}
+		}
+	}
+	return errs
+}
+
+// checkRecords checks the consistency of the records of a config
+func checkRecords(records []Record) []string {
+	errs := checkDuplicates(records)
+	zones := zonesOf(records)
+
+	for _, r := range records {
+		name := strings.ToLower(r.Name())
+		zone := strings.ToLower(r.Zone)
+
+		subDomain := strings.ToLower(r.SubDomain)
+		if subDomain == zone || strings.HasSuffix(subDomain, "."+zone) {
+			errs = append(errs, fmt.Sprintf("%s: Sub domain `%s` ends with the zone, the record would be `%s`",
+				r.Source, r.SubDomain, r.Name()))
+			continue
+		}
+
+		// A record in a sub zone also configured belongs to the sub zone
+		for _, z := range zones {
+			z = strings.ToLower(z)
+			if len(z) > len(zone) && strings.HasSuffix(z, "."+zone) && (name == z || strings.HasSuffix(name, "."+z)) {
+				errs = append(errs, fmt.Sprintf("%s: Record `%s` is outside of zone `%s`, in zone `%s`",
+					r.Source, r.Name(), r.Zone, z))
+				break
+			}
+		}
+
+		// A record below a delegation belongs to the delegated zone,
+		// except the glue records of its name servers
+		for _, ns := range records {
+			if ns.Type() != "NS" || ns.Zone != r.Zone || ns.SubDomain == "" ||
+				!strings.HasSuffix(name, "."+strings.ToLower(ns.Name())) {
+				continue
+			}
+			if (r.Type() == "A" || r.Type() == "AAAA") && isNameServer(records, r) {
+				continue
+			}
+			errs = append(errs, fmt.Sprintf("%s: Record `%s` is outside of zone `%s`, delegated by the NS record at %s",
+				r.Source, r.Name(), r.Zone, ns.Source))
+			break
+		}
+	}
+
+	// A CNAME record can not coexist with other records of the same name
+	for i, r := range records {
+		if r.Type() != "CNAME" {
+			continue
+		}
+		if r.SubDomain == "" {
+			errs = append(errs, fmt.Sprintf("%s: CNAME record not allowed at the apex of zone `%s`", r.Source, r.Zone))
+			continue
+		}
+		for j, o := range records {
+			if j == i || (o.Type() == "CNAME" && j > i) || !strings.EqualFold(o.Name(), r.Name()) {
+				continue
+			}
+			errs = append(errs, fmt.Sprintf("%s: CNAME record `%s` can not coexist with the %s record at %s",
+				r.Source, r.Name(), o.Type(), o.Source))
+		}
+	}
+
+	return errs
+}
+
+// isNameServer returns true if a record is the address of a name server of the records
+func isNameServer(records []Record, r Record) bool {
+	for _, ns := range records {
+		if ns.Type() == "NS" && strings.EqualFold(strings.TrimSuffix(ns.Target, "."), r.Name()) {
+			return true
+		}
+	}
+	return false
+}
+
+// validateRecord checks the type, the sub domain, the target and the TTL of a record
+func validateRecord(r Record) []string {
+	errs := []string{}
+
+	if !IsSupportedFieldType(r.Type()) {
+		errs = append(errs, fmt.Sprintf("Record type `%s` not supported, use one of %s",
+			r.Type(), strings.Join(FieldTypes, ", ")))
+	}
+
+	if err := checkSubDomain(r.SubDomain); err != nil {
+		errs = append(errs, err.Error())
+	} else if r.Zone != "" && len(r.Name()) > 253 {
+		errs = append(errs, fmt.Sprintf("Domain name `%s` longer than 253 characters", r.Name()))
+	}
+
+	if err := checkTarget(r.Type(), r.Target); err != nil {
+		errs = append(errs, err.Error())
+	}
+
+	if r.TTL != 0 && (r.TTL < MinTTL || r.TTL > MaxTTL) {
+		errs = append(errs, fmt.Sprintf("TTL %d out of range, use 0 for the default TTL or a TTL between %d and %d",
+			r.TTL, MinTTL, MaxTTL))
+	}
+
+	return errs
+}
+
+// checkSubDomain checks the syntax of a sub domain, relative to its zone.
+// Its first label can be the wildcard *.
+func checkSubDomain(subDomain string) error {
+	if subDomain == "" {
+		return nil
+	}
+	if strings.HasSuffix(subDomain, ".") {
+		return fmt.Errorf("Invalid sub domain `%s`: must be relative to the zone", subDomain)
+	}
+
+	name := subDomain
+	if name == "*" {
+		return nil
+	}
+	name = strings.TrimPrefix(name, "*.")
+
+	if err := checkHostname(name); err != nil {
+		return fmt.Errorf("Invalid sub domain `%s`: %s", subDomain, err)
+	}
+	return nil
+}
+
+// checkHostname checks the syntax of a domain name: labels separated by dots,
+// of 1 to 63 letters, digits, hyphens or underscores not starting or ending with a hyphen
+func checkHostname(name string) error {
+	if name == "" {
+		return fmt.Errorf("empty name")
+	}
+	for _, label := range strings.Split(name, ".") {
+		if label == "" {
+			return fmt.Errorf("empty label")
+		}
+		if len(label) > 63 {
+			return fmt.Errorf("label `%s` longer than 63 characters", label)
+		}
+		if !labelRegexp.MatchString(label) {
+			return fmt.Errorf("invalid label `%s`", label)
+		}
+	}
+	return nil
+}
+
+// checkTarget checks the target of a record given its type
+func checkTarget(fieldType string, target string) error {
+	if target == "" {
+		return fmt.Errorf("Empty target")
+	}
+
+	fields := strings.Fields(target)
+	switch fieldType {
+	case "A":
+		ip := net.ParseIP(target)
+		if ip == nil || ip.To4() == nil || strings.Contains(target, ":") {
+			return fmt.Errorf("Invalid IPv4 address `%s` for an A record", target)
+		}
+	case "AAAA":
+		ip := net.ParseIP(target)
+		if ip == nil || !strings.Contains(target, ":") {
+			return fmt.Errorf("Invalid IPv6 address `%s` for an AAAA record", target)
+		}
+	case "CNAME", "NS":
+		if err := checkHostname(strings.TrimSuffix(target, ".")); err != nil {
+			return fmt.Errorf("Invalid host name `%s` for a %s record: %s", target, fieldType, err)
+		}
+	case "MX":
+		if len(fields) != 2 || !isUint(fields[0], 16) || checkHostname(strings.TrimSuffix(fields[1], ".")) != nil {
+			return fmt.Errorf("Invalid MX target `%s`, expecting `<priority> <host>`", target)
+		}
+	case "SRV":
+		if len(fields) != 4 || !isUint(fields[0], 16) || !isUint(fields[1], 16) || !isUint(fields[2], 16) ||
+			(fields[3] != "." && checkHostname(strings.TrimSuffix(fields[3], ".")) != nil) {
+			return fmt.Errorf("Invalid SRV target `%s`, expecting `<priority> <weight> <port> <host>`", target)
+		}
+	case "CAA":
+		if len(fields) < 3 || !isUint(fields[0], 8) || !caaTagRegexp.MatchString(fields[1]) {
+			return fmt.Errorf("Invalid CAA target `%s`, expecting `<flags> <tag> <value>`", target)
+		}
+	}
+	return nil
+}
+
+func isUint(s string, bitSize int) bool {
+	_, err := strconv.ParseUint(s, 10, bitSize)
+	return err == nil
+}
@@ -6,52 +6,71 @@ import (
 	"strings"
 
 	"github.com/spf13/cobra"
+	"github.com/thbkrkr/ons/client"
+)
+
+var (
+	addFieldType string
+	addTTL       int
 )
 
 func init() {
+	addCmd.Flags().StringVarP(&addFieldType, "type", "t", client.DefaultFieldType, "Record type (A, AAAA, CNAME, MX, TXT, SRV, NS, CAA)")
+	addCmd.Flags().IntVar(&addTTL, "ttl", 0, "Record TTL in seconds (0 uses the zone default)")
 	OnsCmd.AddCommand(addCmd)
 }
 
 var addCmd = &cobra.Command{
-	Use:   "add [subdomain] [ip]",
+	Use:   "add [subdomain] [target]",
 	Short: "Plan to add a record",
-	Long:  "Plan to add a DNS zone record given a sub domain and an IP. If the IP is not set DOCKER_MACHINE_NAME is used and the IP is resolved using docker machine",
-	Run: func(cmd *cobra.Command, args []string) {
-
-		require("add", 1, 2, args)
+	Long:  "Plan to add a DNS zone record given a sub domain and a target. If the target of an A record is not set DOCKER_MACHINE_NAME is used and the IP is resolved using docker machine",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		err := require("add", 1, 2, args)
+		if err != nil {
+			return err
+		}
 		subDomain := args[0]
-		target := argTarget(args)
+		target, err := argTarget(args)
+		if err != nil {
+			return err
+		}
+
+		zone, err := singleZone("add")
+		if err != nil {
+			return err
+		}
 
-		err := onsClient.Add(zone, subDomain, target)
+		err = onsClient.Add(zone, strings.ToUpper(addFieldType), subDomain, target, addTTL)
 		if err != nil {
-			exit("Fail to add record", err)
+			return fail("Fail to add record", err)
 		}
 
-		plan()
+		_, err = plan()
+		return err
 	},
 }
 
-func argTarget(args []string) string {
+func argTarget(args []string) (string, error) {
 	target := ""
 
-	// Get the IP argument
+	// Get the target argument
 	if len(args) == 2 {
 		target = args[1]
-	} else {
+	} else if strings.ToUpper(addFieldType) == client.DefaultFieldType {
 		// Or get the IP from the current docker machine
 		machine := os.Getenv("DOCKER_MACHINE_NAME")
 		if machine != "" {
 			output, err := exec.Command("docker-machine", "ip", machine).Output()
 			if err != nil {
-				exit("Fail to get ip from `"+machine+"` using docker-machine", nil)
+				return "", fail("Fail to get ip from `"+machine+"` using docker-machine", nil)
 			}
 			target = strings.Replace(string(output), "\n", "", -1)
 		}
 	}
 
 	if target == "" {
-		exit("`add` requires an ip argument or the environment variable DOCKER_MACHINE_NAME", nil)
+		return "", fail("`add` requires a target argument or the environment variable DOCKER_MACHINE_NAME", nil)
 	}
 
-	return target
+	return target, nil
 }
@@ -1,29 +1,150 @@
 package cmd
 
 import (
+	"bufio"
 	"fmt"
+	"os"
+	"strings"
 
+	"github.com/fatih/color"
 	"github.com/spf13/cobra"
+	"github.com/thbkrkr/ons/client"
+)
+
+var (
+	applyAutoApprove bool
+	applyDryRun      bool
+
+	printApplied = color.New(color.Bold, color.FgGreen).PrintfFunc()
 )
 
 func init() {
+	applyCmd.Flags().BoolVar(&applyAutoApprove, "auto-approve", false, "Skip the interactive approval of the plan")
+	applyCmd.Flags().BoolVar(&applyDryRun, "dry-run", false, "Show what would be applied without changing the DNS zone and the state")
 	OnsCmd.AddCommand(applyCmd)
 }
 
 var applyCmd = &cobra.Command{
-	Use:   "apply",
+	Use:   "apply [plan file]",
 	Short: "Changes DNS",
-	Run: func(cmd *cobra.Command, args []string) {
-		fmt.Printf("Refreshing DNS state prior to apply...\n\n")
-
-		added, removed, err := onsClient.Apply(zone)
+	Long:  "Changes DNS according to the configuration or to a plan saved with `ons plan --out [file]`",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		err := require("apply", 0, 1, args)
 		if err != nil {
-			exit("Fail to apply DNS configuration", err)
+			return err
 		}
 
-		if (added + removed) > 0 {
-			fmt.Println("")
+		var plans []*client.Plan
+
+		if len(args) == 1 {
+			plans, err = client.LoadPlans(args[0])
+			if err != nil {
+				return fail("Fail to load plan", err)
+			}
+
+		} else {
+			// The changes are applied right away, the DNS zones are not cached
+			onsClient.SetLive(true)
+			plans, err = computePlans()
+			if err != nil {
+				return err
+			}
 		}
-		cyan("Apply: %d added, %d removed.\n", added, removed)
+
+		return applyPlans(plans)
 	},
 }
+
+// applyPlans prints plans, asks to approve them and applies them
+func applyPlans(plans []*client.Plan) error {
+	if !structured() {
+		printPlans(plans)
+	}
+
+	if !hasChanges(plans) {
+		if structured() {
+			return printOutput(toApplyOutput(plans, nil, nil))
+		}
+		return nil
+	}
+
+	// The plan is not printed with a structured output: it is applied
+	// without approval, only with --auto-approve or --dry-run
+	if structured() && !applyAutoApprove && !applyDryRun {
+		return fail("`apply` requires --auto-approve or --dry-run with a structured output", nil)
+	}
+	if !applyAutoApprove && !structured() {
+		err := confirm()
+		if err != nil {
+			return err
+		}
+	}
+
+	onsClient.SetDryRun(applyDryRun)
+	if applyDryRun {
+		info("\nDry run, the DNS zone and the state are not modified.\n")
+	}
+
+	changes, err := onsClient.ApplyPlans(plans)
+	result := toApplyOutput(plans, changes, err)
+
+	if structured() {
+		outErr := printOutput(result)
+		if err != nil {
+			return exitCode(1)
+		}
+		return outErr
+	}
+
+	fmt.Println()
+	for _, c := range changes {
+		r := c.Record
+		printApplied("%-5s %-16s %s  %s\n", r.Type(), r.Target, r.Name(), c.Action)
+	}
+	if err != nil {
+		fmt.Println()
+		cyan("Apply aborted: %d added, %d updated, %d removed, %d not applied.\n",
+			result.Added, result.Updated, result.Removed, result.NotApplied)
+		if len(changes) > 0 {
+			info("The state records the changes applied, run `ons apply` again to apply the remaining changes.\n")
+		}
+		return fail("Fail to apply DNS configuration", err)
+	}
+
+	if len(changes) > 0 {
+		fmt.Println("")
+	}
+	cyan("Apply: %d added, %d updated, %d removed.\n", result.Added, result.Updated, result.Removed)
+
+	return nil
+}
+
+func hasChanges(plans []*client.Plan) bool {
+	for _, p := range plans {
+		if p.HasChanges() {
+			return true
+		}
+	}
+	return false
+}
+
+// confirm asks to approve the plan and fails if it is not approved
+func confirm() error {
+	return ask("\nDo you want to perform these actions? Only 'yes' will be accepted to approve.\n  Enter a value: ",
+		"Apply cancelled")
+}
+
+// ask asks a question and fails with a message if the answer is not 'yes'
+func ask(prompt string, cancelled string) error {
+	if structured() {
+		fmt.Fprint(os.Stderr, prompt)
+	} else {
+		fmt.Print(prompt)
+	}
+
+	answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+	if strings.TrimSpace(answer) != "yes" {
+		return fail(cancelled, nil)
+	}
+	return nil
+}
@@ -0,0 +1,46 @@
+package cmd
+
+import (
+	"os"
+
+	"github.com/spf13/cobra"
+	"github.com/thbkrkr/ons/client"
+)
+
+var (
+	exportFormat string
+	exportLive   bool
+	exportTTL    int
+)
+
+func init() {
+	exportCmd.Flags().StringVar(&exportFormat, "format", "bind", "Output format (bind)")
+	exportCmd.Flags().BoolVar(&exportLive, "live", false, "Export the records of the DNS zone instead of the configured records")
+	exportCmd.Flags().IntVar(&exportTTL, "ttl", client.DefaultZoneFileTTL, "Default TTL ($TTL) of the zone file")
+	OnsCmd.AddCommand(exportCmd)
+}
+
+var exportCmd = &cobra.Command{
+	Use:   "export",
+	Short: "Export records as a zone file",
+	Long:  "Export the configured records, or the records of the DNS zone with --live, as a zone file in the BIND format",
+	RunE: func(cmd *cobra.Command, args []string) error {
+
+		if exportFormat != "bind" {
+			return fail("Format `"+exportFormat+"` not supported", nil)
+		}
+
+		zones, err := zones()
+		if err != nil {
+			return err
+		}
+
+		for _, zone := range zones {
+			err := onsClient.Export(os.Stdout, zone, exportLive, exportTTL)
+			if err != nil {
+				return fail("Fail to export records", err)
+			}
+		}
+		return nil
+	},
+}
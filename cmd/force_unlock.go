@@ -0,0 +1,44 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+)
+
+var forceUnlockForce bool
+
+func init() {
+	forceUnlockCmd.Flags().BoolVarP(&forceUnlockForce, "force", "f", false, "Skip the interactive confirmation")
+	OnsCmd.AddCommand(forceUnlockCmd)
+	needs(forceUnlockCmd, needBackend)
+}
+
+var forceUnlockCmd = &cobra.Command{
+	Use:   "force-unlock [lock id]",
+	Short: "Remove the lock of the state",
+	Long: "Remove the lock of the state left by an interrupted ons command. " +
+		"The lock ID is given by the command failing to lock the state.",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		err := require("force-unlock", 1, 1, args)
+		if err != nil {
+			return err
+		}
+
+		if !forceUnlockForce {
+			err = ask(fmt.Sprintf("Do you really want to remove the lock %s? Only 'yes' will be accepted to confirm.\n  Enter a value: ", args[0]),
+				"Force-unlock cancelled")
+			if err != nil {
+				return err
+			}
+		}
+
+		err = backend.Unlock(args[0])
+		if err != nil {
+			return fail("Fail to unlock the state", err)
+		}
+
+		info("\nState unlocked.\n")
+		return nil
+	},
+}
@@ -0,0 +1,87 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+	"github.com/thbkrkr/ons/client"
+)
+
+var (
+	importAll  bool
+	importFrom string
+)
+
+func init() {
+	importCmd.Flags().BoolVar(&importAll, "all", false, "Import all records of the zone")
+	importCmd.Flags().StringVar(&importFrom, "from", "", "Import the records of a zone file in the BIND format in the config")
+	OnsCmd.AddCommand(importCmd)
+}
+
+var importCmd = &cobra.Command{
+	Use:   "import [subdomain]",
+	Short: "Import existing records in the config",
+	Long: "Import records of the DNS zone matching a sub domain, or all records with --all, in the config and the state. " +
+		"With --from, import the records of a zone file in the BIND format in the config.",
+	RunE: func(cmd *cobra.Command, args []string) error {
+
+		var records client.Records
+		var err error
+
+		switch {
+		case importFrom != "":
+			err = require("import --from", 0, 0, args)
+			if err != nil {
+				return err
+			}
+			records, err = onsClient.ImportZoneFile(importFrom, zone)
+			if err != nil {
+				return fail("Fail to import records", err)
+			}
+		case importAll:
+			err = require("import --all", 0, 0, args)
+			if err != nil {
+				return err
+			}
+			records, err = importZone("", true)
+		default:
+			err = require("import", 1, 1, args)
+			if err != nil {
+				return err
+			}
+			records, err = importZone(args[0], false)
+		}
+		if err != nil {
+			return err
+		}
+
+		if structured() {
+			return printOutput(toRecordsOutput(records))
+		}
+
+		for _, record := range records {
+			record.Print()
+		}
+		if len(records) > 0 {
+			fmt.Println()
+		}
+		cyan("Import: %d imported.\n\n", len(records))
+
+		_, err = plan()
+		return err
+	},
+}
+
+// importZone imports the records of the DNS zone matching a sub domain
+func importZone(subDomain string, all bool) (client.Records, error) {
+	zone, err := singleZone("import")
+	if err != nil {
+		return nil, err
+	}
+
+	records, err := onsClient.Import(zone, subDomain, all)
+	if err != nil {
+		return nil, fail("Fail to import records", err)
+	}
+	return records, nil
+}
@@ -1,6 +1,9 @@
 package cmd
 
-import "github.com/spf13/cobra"
+import (
+	"github.com/spf13/cobra"
+	"github.com/thbkrkr/ons/client"
+)
 
 func init() {
 	OnsCmd.AddCommand(lsCmd)
@@ -9,15 +12,29 @@ func init() {
 var lsCmd = &cobra.Command{
 	Use:   "ls",
 	Short: "List all DNS records of the zone",
-	Run: func(cmd *cobra.Command, args []string) {
+	RunE: func(cmd *cobra.Command, args []string) error {
 
-		records, err := onsClient.Ls(zone)
+		zones, err := zones()
 		if err != nil {
-			exit("Fail to list records", err)
+			return err
 		}
 
-		for _, record := range records {
+		all := []client.Record{}
+		for _, zone := range zones {
+			records, err := onsClient.Ls(zone)
+			if err != nil {
+				return fail("Fail to list records", err)
+			}
+			all = append(all, records...)
+		}
+
+		if structured() {
+			return printOutput(toRecordsOutput(all))
+		}
+
+		for _, record := range all {
 			record.Print()
 		}
+		return nil
 	},
 }
@@ -0,0 +1,211 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/thbkrkr/ons/client"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// Output formats
+const (
+	textOutput = "text"
+	jsonOutput = "json"
+	yamlOutput = "yaml"
+)
+
+var output string
+
+// recordOutput represents a DNS zone record in the structured outputs
+type recordOutput struct {
+	Zone      string `json:"zone" yaml:"zone"`
+	FieldType string `json:"fieldType" yaml:"fieldType"`
+	SubDomain string `json:"subDomain" yaml:"subDomain"`
+	Target    string `json:"target" yaml:"target"`
+	TTL       int    `json:"ttl" yaml:"ttl"`
+	ID        int64  `json:"id" yaml:"id"`
+	Managed   bool   `json:"managed" yaml:"managed"`
+}
+
+// updateOutput represents an in-place update of a record in the structured outputs
+type updateOutput struct {
+	From recordOutput `json:"from" yaml:"from"`
+	To   recordOutput `json:"to" yaml:"to"`
+}
+
+// planOutput represents the plan of a DNS zone in the structured outputs
+type planOutput struct {
+	Zone     string         `json:"zone" yaml:"zone"`
+	ToAdd    []recordOutput `json:"toAdd" yaml:"toAdd"`
+	ToUpdate []updateOutput `json:"toUpdate" yaml:"toUpdate"`
+	ToRm     []recordOutput `json:"toRm" yaml:"toRm"`
+}
+
+// changeOutput represents a change applied on a record in the structured outputs
+type changeOutput struct {
+	Action string       `json:"action" yaml:"action"`
+	Record recordOutput `json:"record" yaml:"record"`
+}
+
+// applyOutput represents the result of an apply in the structured outputs
+type applyOutput struct {
+	Changes    []changeOutput `json:"changes" yaml:"changes"`
+	Added      int            `json:"added" yaml:"added"`
+	Updated    int            `json:"updated" yaml:"updated"`
+	Removed    int            `json:"removed" yaml:"removed"`
+	NotApplied int            `json:"notApplied" yaml:"notApplied"`
+	Error      string         `json:"error,omitempty" yaml:"error,omitempty"`
+}
+
+// snapshotOutput represents a snapshot of the state in the structured outputs
+type snapshotOutput struct {
+	Serial  int64  `json:"serial" yaml:"serial"`
+	Lineage string `json:"lineage" yaml:"lineage"`
+	Created string `json:"created" yaml:"created"`
+	Records int    `json:"records" yaml:"records"`
+	Current bool   `json:"current" yaml:"current"`
+}
+
+// validateOutput represents the result of the validation of the config in the structured outputs
+type validateOutput struct {
+	Config  string   `json:"config" yaml:"config"`
+	Valid   bool     `json:"valid" yaml:"valid"`
+	Records int      `json:"records" yaml:"records"`
+	Zones   []string `json:"zones" yaml:"zones"`
+	Errors  []string `json:"errors" yaml:"errors"`
+}
+
+func checkOutput() error {
+	switch output {
+	case textOutput, jsonOutput, yamlOutput:
+		return nil
+	}
+	return fail(fmt.Sprintf("Output `%s` not supported, use text, json or yaml", output), nil)
+}
+
+// structured returns true if the output is JSON or YAML
+func structured() bool {
+	return output != textOutput
+}
+
+// info prints a message only with the text output
+func info(format string, a ...interface{}) {
+	if !structured() {
+		fmt.Printf(format, a...)
+	}
+}
+
+// printOutput prints a value in the structured output format
+func printOutput(v interface{}) error {
+	var data []byte
+	var err error
+
+	if output == yamlOutput {
+		data, err = yaml.Marshal(v)
+	} else {
+		data, err = json.MarshalIndent(v, "", "  ")
+		data = append(data, '\n')
+	}
+	if err != nil {
+		return fail("Fail to format output", err)
+	}
+
+	fmt.Print(string(data))
+	return nil
+}
+
+func toRecordOutput(r client.Record) recordOutput {
+	return recordOutput{
+		Zone:      r.Zone,
+		FieldType: r.Type(),
+		SubDomain: r.SubDomain,
+		Target:    r.Target,
+		TTL:       r.TTL,
+		ID:        r.ID,
+		Managed:   r.Managed != "",
+	}
+}
+
+func toRecordsOutput(records []client.Record) []recordOutput {
+	out := []recordOutput{}
+	for _, r := range records {
+		out = append(out, toRecordOutput(r))
+	}
+	return out
+}
+
+func toPlansOutput(plans []*client.Plan) []planOutput {
+	out := []planOutput{}
+	for _, p := range plans {
+		updates := []updateOutput{}
+		for _, u := range p.ToUpdate {
+			updates = append(updates, updateOutput{From: toRecordOutput(u.From), To: toRecordOutput(u.To)})
+		}
+		out = append(out, planOutput{
+			Zone:     p.Zone,
+			ToAdd:    toRecordsOutput(p.ToAdd),
+			ToUpdate: updates,
+			ToRm:     toRecordsOutput(p.ToRm),
+		})
+	}
+	return out
+}
+
+func toApplyOutput(plans []*client.Plan, changes []client.Change, err error) applyOutput {
+	out := applyOutput{Changes: []changeOutput{}}
+	for _, p := range plans {
+		out.NotApplied += len(p.ToAdd) + len(p.ToUpdate) + len(p.ToRm)
+	}
+	out.NotApplied -= len(changes)
+	for _, c := range changes {
+		out.Changes = append(out.Changes, changeOutput{Action: c.Action, Record: toRecordOutput(c.Record)})
+		switch c.Action {
+		case client.Added:
+			out.Added++
+		case client.Updated:
+			out.Updated++
+		case client.Removed:
+			out.Removed++
+		}
+	}
+	if err != nil {
+		out.Error = err.Error()
+	}
+	return out
+}
+
+func toSnapshotsOutput(snapshots []client.Snapshot, serial int64) []snapshotOutput {
+	out := []snapshotOutput{}
+	for _, s := range snapshots {
+		out = append(out, snapshotOutput{
+			Serial:  s.Serial,
+			Lineage: s.Lineage,
+			Created: s.Created.Format(time.RFC3339),
+			Records: s.Records,
+			Current: s.Serial == serial,
+		})
+	}
+	return out
+}
+
+func toValidateOutput(configPath string, records []client.Record, err *client.ConfigError) validateOutput {
+	out := validateOutput{Config: configPath, Valid: err == nil, Records: len(records), Zones: []string{}, Errors: []string{}}
+	for _, r := range records {
+		found := false
+		for _, z := range out.Zones {
+			if z == r.Zone {
+				found = true
+				break
+			}
+		}
+		if !found {
+			out.Zones = append(out.Zones, r.Zone)
+		}
+	}
+	if err != nil {
+		out.Errors = err.Errors
+	}
+	return out
+}
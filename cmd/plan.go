@@ -5,48 +5,126 @@ import (
 
 	"github.com/fatih/color"
 	"github.com/spf13/cobra"
+	"github.com/thbkrkr/ons/client"
 )
 
 var (
 	printAddition = color.New(color.FgGreen).PrintfFunc()
+	printUpdate   = color.New(color.FgYellow).PrintfFunc()
 	printRemoval  = color.New(color.FgRed).PrintfFunc()
 )
 
+var (
+	planOut              string
+	planDetailedExitCode bool
+	planRefresh          bool
+)
+
 func init() {
+	planCmd.Flags().StringVar(&planOut, "out", "", "Write the plan to a `file` to apply it later with ons apply")
+	planCmd.Flags().BoolVar(&planDetailedExitCode, "detailed-exitcode", false,
+		"Return 0 when there are no changes, 1 on errors and 2 when there are changes")
+	planCmd.Flags().BoolVar(&planRefresh, "refresh", true,
+		"List the DNS zones, plan against the cached records or the state otherwise")
 	OnsCmd.AddCommand(planCmd)
 }
 
 var planCmd = &cobra.Command{
 	Use:   "plan",
 	Short: "Show the execution plan",
-	Run: func(cmd *cobra.Command, args []string) {
+	RunE: func(cmd *cobra.Command, args []string) error {
+
+		if planOut != "" && !planRefresh {
+			return fail("A plan computed with --refresh=false can not be saved to be applied", nil)
+		}
+
+		onsClient.SetRefresh(planRefresh)
+
+		plans, err := plan()
+		if err != nil {
+			return err
+		}
+
+		if planOut != "" {
+			err := client.SavePlans(planOut, plans)
+			if err != nil {
+				return fail("Fail to save plan", err)
+			}
+			info("\nPlan saved to %s, apply it with `ons apply %s`.\n", planOut, planOut)
+		}
 
-		plan()
+		if planDetailedExitCode && hasChanges(plans) {
+			return exitCode(2)
+		}
+		return nil
 	},
 }
 
-func plan() {
-	fmt.Printf("Refreshing DNS zone state prior to plan...\n\n")
+func plan() ([]*client.Plan, error) {
+	plans, err := computePlans()
+	if err != nil {
+		return nil, err
+	}
+
+	return plans, printPlans(plans)
+}
+
+func computePlans() ([]*client.Plan, error) {
+	if planRefresh {
+		info("Refreshing DNS zone state prior to plan...\n\n")
+	} else {
+		info("Planning against the cached DNS zone records or the state...\n\n")
+	}
 
-	toAdd, toRm, err := onsClient.Plan(zone)
+	zones, err := zones()
 	if err != nil {
-		exit("Fail to plan", err)
+		return nil, err
 	}
 
-	for _, r := range toAdd {
-		printAddition("+ dns record: %-16s %s.%s\n", r.Target, r.SubDomain, zone)
+	plans := []*client.Plan{}
+	for _, zone := range zones {
+		p, err := onsClient.Plan(zone)
+		if err != nil {
+			return nil, fail("Fail to plan", err)
+		}
+		plans = append(plans, p)
+	}
+
+	return plans, nil
+}
+
+func printPlans(plans []*client.Plan) error {
+	if structured() {
+		return printOutput(toPlansOutput(plans))
 	}
-	for _, r := range toRm {
-		comment := ""
-		if r.ID == 0 {
-			comment = "(already removed from the DNS zone)"
+
+	toAdd, toUpdate, toRm := 0, 0, 0
+
+	for _, p := range plans {
+		for _, r := range p.ToAdd {
+			printAddition("+ dns record: %-5s %-16s %s\n", r.Type(), r.Target, r.Name())
+		}
+		for _, u := range p.ToUpdate {
+			printUpdate("~ dns record: %-5s %-16s %s  %s\n", u.To.Type(), u.To.Target, u.To.Name(), u.Changes())
 		}
-		printRemoval("- dns record: %-16s %s.%s %s\n", r.Target, r.SubDomain, zone, comment)
+		for _, r := range p.ToRm {
+			comment := ""
+			if r.ID == 0 {
+				comment = "(already removed from the DNS zone)"
+			}
+			printRemoval("- dns record: %-5s %-16s %s %s\n", r.Type(), r.Target, r.Name(), comment)
+		}
+
+		toAdd += len(p.ToAdd)
+		toUpdate += len(p.ToUpdate)
+		toRm += len(p.ToRm)
 	}
 
-	if len(toAdd)+len(toRm) > 0 {
+	if toAdd+toUpdate+toRm > 0 {
 		fmt.Println()
 	}
 
-	cyan("Plan: %d to add, %d to remove.\n", len(toAdd), len(toRm))
+	cyan("Plan: %d to add, %d to update, %d to remove.\n", toAdd, toUpdate, toRm)
+
+	return nil
 }
@@ -1,28 +1,44 @@
 package cmd
 
-import "github.com/spf13/cobra"
+import (
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+var rmFieldType string
 
 var rmCmd = &cobra.Command{
-	Use:   "rm",
+	Use:   "rm [subdomain] [target]",
 	Short: "Plan to remove records matching a sub domain",
-	Run: func(cmd *cobra.Command, args []string) {
+	RunE: func(cmd *cobra.Command, args []string) error {
 
-		require("rm", 1, 2, args)
+		err := require("rm", 1, 2, args)
+		if err != nil {
+			return err
+		}
 		subDomain := args[0]
 		target := ""
 		if len(args) == 2 {
 			target = args[1]
 		}
 
-		err := onsClient.Rm(zone, subDomain, target)
+		zone, err := singleZone("rm")
+		if err != nil {
+			return err
+		}
+
+		err = onsClient.Rm(zone, strings.ToUpper(rmFieldType), subDomain, target)
 		if err != nil {
-			exit("Fail to remove record", err)
+			return fail("Fail to remove record", err)
 		}
 
-		plan()
+		_, err = plan()
+		return err
 	},
 }
 
 func init() {
+	rmCmd.Flags().StringVarP(&rmFieldType, "type", "t", "", "Only remove records of this type")
 	OnsCmd.AddCommand(rmCmd)
 }
@@ -0,0 +1,42 @@
+package cmd
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	rollbackCmd.Flags().BoolVar(&applyAutoApprove, "auto-approve", false, "Skip the interactive approval of the plan")
+	rollbackCmd.Flags().BoolVar(&applyDryRun, "dry-run", false, "Show what would be applied without changing the DNS zone and the state")
+	OnsCmd.AddCommand(rollbackCmd)
+}
+
+var rollbackCmd = &cobra.Command{
+	Use:   "rollback [serial]",
+	Short: "Changes DNS back to a snapshot of the state",
+	Long: "Changes DNS back to the records of a snapshot of the state listed by `ons state list`. " +
+		"The config is not modified, revert it to not apply the changes again with the next `ons apply`.",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		err := require("rollback", 1, 1, args)
+		if err != nil {
+			return err
+		}
+
+		serial, err := strconv.ParseInt(args[0], 10, 64)
+		if err != nil {
+			return fail(fmt.Sprintf("Invalid serial `%s`", args[0]), nil)
+		}
+
+		info("Refreshing DNS zone state prior to plan...\n\n")
+
+		onsClient.SetLive(true)
+		plans, err := onsClient.RollbackPlans(serial)
+		if err != nil {
+			return fail("Fail to plan the rollback", err)
+		}
+
+		return applyPlans(plans)
+	},
+}
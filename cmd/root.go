@@ -9,15 +9,31 @@ import (
 	"github.com/fatih/color"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
+	"github.com/thbkrkr/ons/backend/consul"
+	httpbackend "github.com/thbkrkr/ons/backend/http"
+	"github.com/thbkrkr/ons/backend/s3"
 	"github.com/thbkrkr/ons/client"
+	"github.com/thbkrkr/ons/provider/ovh"
+	"github.com/thbkrkr/ons/provider/rfc2136"
 )
 
 // OnsCmd is the ONS CLI main command
 var OnsCmd = &cobra.Command{
 	Use:   "ons",
 	Short: "Utility to manage OVH DNS zone.",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if printVersion {
+			fmt.Printf("ons %s\n", Version)
+			return nil
+		}
+		return cmd.Help()
+	},
 }
 
+// Version is the version of ons, set at build time with
+// -ldflags "-X github.com/thbkrkr/ons/cmd.Version=<version>"
+var Version = "dev"
+
 const (
 	envPrefix = "ons"
 )
@@ -27,8 +43,12 @@ var (
 	zone      string
 
 	onsDir     string
-	statePath  string
 	configPath string
+	cachePath  string
+	backend    client.StateBackend
+	lock       *client.Lock
+
+	printVersion bool
 
 	magenta = color.New(color.FgMagenta).SprintFunc()
 	green   = color.New(color.FgGreen).SprintFunc()
@@ -40,45 +60,354 @@ func init() {
 	viper.AutomaticEnv()
 
 	viper.SetDefault("path", "dns")
+	viper.SetDefault("provider", "ovh")
 	viper.SetDefault("endpoint", "ovh-eu")
+	viper.SetDefault("ovh_workers", ovh.DefaultWorkers)
+	viper.SetDefault("ovh_rate_limit", ovh.DefaultRateLimit)
+	viper.SetDefault("ovh_retries", ovh.DefaultMaxRetries)
+	viper.SetDefault("tsig_algorithm", "hmac-sha256")
+	viper.SetDefault("default_ttl", 3600)
+	viper.SetDefault("cache_ttl", client.DefaultCacheTTL.String())
+	viper.SetDefault("backend", "local")
+	viper.SetDefault("state_history", client.DefaultStateHistory)
+	viper.SetDefault("s3_endpoint", "https://s3.amazonaws.com")
+	viper.SetDefault("s3_region", "us-east-1")
+	viper.SetDefault("consul_address", "http://127.0.0.1:8500")
+
+	OnsCmd.PersistentFlags().StringVar(&zone, "zone", viper.GetString("zone"),
+		"DNS zone to manage, all zones of the config by default (ONS_ZONE)")
+	OnsCmd.PersistentFlags().StringVar(&output, "output", textOutput, "Output format: text, json or yaml")
+	OnsCmd.Flags().BoolVar(&printVersion, "version", false, "Print the version of ons")
+
+	OnsCmd.PersistentPreRunE = func(cmd *cobra.Command, args []string) error {
+		// The arguments are parsed: the errors are no more usage errors
+		// and are logged by Execute
+		OnsCmd.SilenceErrors = true
+		OnsCmd.SilenceUsage = true
+
+		err := checkOutput()
+		if err != nil {
+			return err
+		}
+		return setup(cmd)
+	}
+	OnsCmd.PersistentPostRun = func(cmd *cobra.Command, args []string) {
+		releaseLock()
+	}
+
+	needs(OnsCmd, needNothing)
+}
+
+// need is what a command needs to run
+type need int
+
+const (
+	// needClient is the default need: the state backend, the lock
+	// of the state and the ons client, which loads the config and the state
+	needClient need = iota
+	// needBackend is the need of the state backend only
+	needBackend
+	// needNothing is the need of commands working offline
+	needNothing
+)
+
+var commandNeeds = map[*cobra.Command]need{}
+
+// needs declares what a command needs to run, the ons client by default
+func needs(cmd *cobra.Command, n need) {
+	commandNeeds[cmd] = n
+}
+
+// setup creates what a command needs. The state is locked from the creation
+// of the client, which loads it, to the end of the command.
+func setup(cmd *cobra.Command) error {
+	err := initPaths()
+	if err != nil {
+		return err
+	}
+
+	vars, err = loadVars()
+	if err != nil {
+		return fail("Fail to load the variables", err)
+	}
+
+	n := commandNeeds[cmd]
+	if n == needNothing {
+		return nil
+	}
+
+	err = initBackend()
+	if err != nil || n == needBackend {
+		return err
+	}
+
+	err = acquireLock(cmd.CommandPath())
+	if err != nil {
+		return err
+	}
+
+	return initClient()
+}
+
+// Execute runs the ons command and returns its exit code
+func Execute() int {
+	err := OnsCmd.Execute()
+	releaseLock()
+	if err == nil {
+		return 0
+	}
+
+	// Usage errors are printed by cobra
+	if !OnsCmd.SilenceErrors {
+		return 1
+	}
+
+	e, ok := err.(*exitError)
+	if !ok {
+		e = &exitError{code: 1, msg: err.Error()}
+	}
+	if e.msg != "" {
+		fmt.Println()
+		if e.err != nil {
+			log.WithError(e.err).Error(e.msg)
+		} else {
+			log.Error(e.msg)
+		}
+	}
+
+	return e.code
+}
+
+func initPaths() error {
+	env := &envVars{}
+	onsDir = env.get("path")
+	if err := env.err(); err != nil {
+		return fail("Fail to start ons", err)
+	}
+
+	configPath = client.FindConfig(onsDir)
+	cachePath = onsDir + "/ons.cache.json"
+
+	return nil
+}
+
+func initBackend() error {
+	var err error
+	backend, err = newBackend()
+	if err != nil {
+		return fail("Fail to start ons", err)
+	}
+	return nil
+}
 
-	zone = env("zone")
-	onsDir = env("path")
+func initClient() error {
+	provider, err := newProvider()
+	if err != nil {
+		return fail("Fail to start ons", err)
+	}
 
-	statePath = onsDir + "/ons.state.json"
-	configPath = onsDir + "/ons.config.json"
+	onsClient, err = client.NewOnsClient(provider, backend, configPath, zone, vars)
+	if configErr, ok := err.(*client.ConfigError); ok {
+		return invalidConfig(configErr)
+	}
+	if err != nil {
+		return fail("Fail to start ons", err)
+	}
+
+	onsClient.SetStateHistory(viper.GetInt("state_history"))
 
+	if ttl := viper.GetDuration("cache_ttl"); ttl > 0 {
+		err = onsClient.EnableCache(cachePath, ttl)
+		if err != nil {
+			return fail("Fail to start ons", err)
+		}
+	}
+
+	return nil
+}
+
+// acquireLock locks the state for an operation
+func acquireLock(operation string) error {
 	var err error
-	onsClient, err = client.NewOnsClient(statePath, configPath,
-		env("endpoint"), env("ak"), env("as"), env("ck"))
+	lock, err = client.AcquireLock(backend, operation)
+	if err != nil {
+		return fail("Fail to lock the state", err)
+	}
+	return nil
+}
+
+// releaseLock unlocks the state if it is locked
+func releaseLock() {
+	if lock == nil {
+		return
+	}
+
+	err := lock.Release()
+	lock = nil
+	if err != nil {
+		log.WithError(err).Error("Fail to unlock the state")
+	}
+}
+
+// newProvider creates the DNS provider set by ONS_PROVIDER
+func newProvider() (client.Provider, error) {
+	env := &envVars{}
+
+	switch env.get("provider") {
+	case "ovh":
+		endpoint, ak, as, ck := env.get("endpoint"), env.get("ak"), env.get("as"), env.get("ck")
+		if err := env.err(); err != nil {
+			return nil, err
+		}
+		provider, err := ovh.NewProvider(endpoint, ak, as, ck)
+		if err != nil {
+			return nil, err
+		}
+		provider.Workers = viper.GetInt("ovh_workers")
+		provider.MaxRetries = viper.GetInt("ovh_retries")
+		provider.SetRateLimit(viper.GetFloat64("ovh_rate_limit"), provider.Workers)
+		return provider, nil
+	case "rfc2136":
+		secret := ""
+		keyName := viper.GetString("tsig_name")
+		if keyName != "" {
+			secret = env.get("tsig_secret")
+		}
+		server, algorithm := env.get("rfc2136_server"), env.get("tsig_algorithm")
+		if err := env.err(); err != nil {
+			return nil, err
+		}
+		return rfc2136.NewProvider(server, keyName, secret, algorithm, viper.GetInt("default_ttl"))
+	case "":
+		return nil, env.err()
+	}
+
+	return nil, fmt.Errorf("Provider `%s` not supported, use ovh or rfc2136", viper.GetString("provider"))
+}
+
+// newBackend creates the state backend set by ONS_BACKEND
+func newBackend() (client.StateBackend, error) {
+	env := &envVars{}
 
+	switch env.get("backend") {
+	case "local":
+		return client.NewLocalBackend(onsDir), nil
+	case "s3":
+		endpoint, bucket, region := env.get("s3_endpoint"), env.get("s3_bucket"), env.get("s3_region")
+		accessKey, secretKey := env.get("s3_access_key"), env.get("s3_secret_key")
+		if err := env.err(); err != nil {
+			return nil, err
+		}
+		backend, err := s3.NewBackend(endpoint, bucket, viper.GetString("s3_prefix"), region, accessKey, secretKey)
+		if err != nil {
+			return nil, err
+		}
+		backend.SetSessionToken(viper.GetString("s3_session_token"))
+		return backend, nil
+	case "http":
+		address := env.get("http_address")
+		if err := env.err(); err != nil {
+			return nil, err
+		}
+		return httpbackend.NewBackend(address, viper.GetString("http_username"), viper.GetString("http_password"))
+	case "consul":
+		address, path := env.get("consul_address"), env.get("consul_path")
+		if err := env.err(); err != nil {
+			return nil, err
+		}
+		return consul.NewBackend(address, path, viper.GetString("consul_token"))
+	case "":
+		return nil, env.err()
+	}
+
+	return nil, fmt.Errorf("Backend `%s` not supported, use local, s3, http or consul", viper.GetString("backend"))
+}
+
+// zones returns the zones to manage: the zone set by --zone or ONS_ZONE,
+// or all the zones of the config and the state
+func zones() ([]string, error) {
+	if zone != "" {
+		return []string{zone}, nil
+	}
+
+	zones := onsClient.Zones()
+	if len(zones) == 0 {
+		return nil, fail("No zone to manage, set --zone or ONS_ZONE", nil)
+	}
+
+	return zones, nil
+}
+
+// singleZone returns the zone to manage for commands working on one zone
+func singleZone(cmd string) (string, error) {
+	zones, err := zones()
 	if err != nil {
-		exit("Fail to start ons", err)
+		return "", err
 	}
+	if len(zones) > 1 {
+		return "", fail(fmt.Sprintf("`%s` requires a zone, set --zone or ONS_ZONE", cmd), nil)
+	}
+	return zones[0], nil
+}
+
+// envVars reads required environment variables, collecting the missing ones
+type envVars struct {
+	missing []string
 }
 
-func env(key string) string {
+func (e *envVars) get(key string) string {
 	value := viper.GetString(key)
 	if value == "" {
-		log.Errorf("%s not defined.", strings.ToUpper(envPrefix+"_"+key))
-		os.Exit(1)
+		e.missing = append(e.missing, strings.ToUpper(envPrefix+"_"+key))
 	}
 	return value
 }
 
-func require(cmd string, min int, max int, args []string) {
+// err returns an error listing the missing environment variables
+func (e *envVars) err() error {
+	if len(e.missing) == 0 {
+		return nil
+	}
+	return fmt.Errorf("%s not defined", strings.Join(e.missing, ", "))
+}
+
+func require(cmd string, min int, max int, args []string) error {
 	if len(args) < min || len(args) > max {
-		exit(fmt.Sprintf("`%s` requires %d argument.", cmd, max), nil)
+		return fail(fmt.Sprintf("`%s` requires %d argument.", cmd, max), nil)
 	}
+	return nil
 }
 
-func exit(msg string, err error) {
-	fmt.Println()
-	if err != nil {
-		log.WithError(err).Error(msg)
-	} else {
-		log.Error(msg)
+// exitError is the error of a command exiting with a status code,
+// logged by Execute unless its message is empty
+type exitError struct {
+	code int
+	msg  string
+	err  error
+}
+
+func (e *exitError) Error() string {
+	if e.err == nil {
+		return e.msg
 	}
+	return e.msg + ": " + e.err.Error()
+}
+
+// fail returns the error of a failed command
+func fail(msg string, err error) error {
+	return &exitError{code: 1, msg: msg, err: err}
+}
 
-	os.Exit(1)
+// exitCode returns the error of a command exiting silently with a status code
+func exitCode(code int) error {
+	return &exitError{code: code}
+}
+
+// invalidConfig prints the errors of an invalid config
+// and returns the error of the command
+func invalidConfig(err *client.ConfigError) error {
+	for _, e := range err.Errors {
+		fmt.Fprintln(os.Stderr, e)
+	}
+	return fail("Invalid config", nil)
 }
@@ -0,0 +1,50 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	stateCmd.AddCommand(stateListCmd)
+	OnsCmd.AddCommand(stateCmd)
+}
+
+var stateCmd = &cobra.Command{
+	Use:   "state",
+	Short: "Manage the versions of the state",
+}
+
+var stateListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List the snapshots of the state",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		snapshots, err := onsClient.Snapshots()
+		if err != nil {
+			return fail("Fail to list the snapshots of the state", err)
+		}
+
+		serial, lineage := onsClient.StateVersion()
+
+		if structured() {
+			return printOutput(toSnapshotsOutput(snapshots, serial))
+		}
+
+		if len(snapshots) == 0 {
+			info("No snapshot of the state.\n")
+			return nil
+		}
+
+		info("Lineage: %s\n\n", lineage)
+		for i := len(snapshots) - 1; i >= 0; i-- {
+			s := snapshots[i]
+			current := ""
+			if s.Serial == serial {
+				current = "(current)"
+			}
+			fmt.Printf("%-6d %s  %4d records %s\n", s.Serial, s.Created.Local().Format("2006-01-02 15:04:05"), s.Records, current)
+		}
+		return nil
+	},
+}
@@ -0,0 +1,62 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+	"github.com/thbkrkr/ons/client"
+)
+
+var validateExpand bool
+
+func init() {
+	validateCmd.Flags().BoolVar(&validateExpand, "expand", false,
+		"Print the config expanded with its variables, the lines of the errors of a templated config being its lines")
+	OnsCmd.AddCommand(validateCmd)
+	needs(validateCmd, needNothing)
+}
+
+var validateCmd = &cobra.Command{
+	Use:   "validate",
+	Short: "Check the config",
+	Long: "Check the syntax of the config and of its records, the duplicate records, " +
+		"the CNAME records coexisting with other records and the records outside of their zone. " +
+		"Neither the DNS provider nor the state are used: no credentials are required.",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		err := require("validate", 0, 0, args)
+		if err != nil {
+			return err
+		}
+
+		if validateExpand {
+			data, err := client.ExpandConfig(configPath, vars)
+			if err != nil {
+				return fail("Fail to expand the config", err)
+			}
+			fmt.Print(string(data))
+			return nil
+		}
+
+		records, err := client.ValidateConfig(configPath, zone, vars)
+		configErr, invalid := err.(*client.ConfigError)
+		if err != nil && !invalid {
+			return fail("Fail to validate the config", err)
+		}
+
+		if structured() {
+			outErr := printOutput(toValidateOutput(configPath, records, configErr))
+			if invalid {
+				return exitCode(1)
+			}
+			return outErr
+		}
+
+		if invalid {
+			return invalidConfig(configErr)
+		}
+
+		out := toValidateOutput(configPath, records, nil)
+		info("%s is valid: %d records in %d zones.\n", configPath, out.Records, len(out.Zones))
+		return nil
+	},
+}
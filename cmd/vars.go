@@ -0,0 +1,73 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/thbkrkr/ons/client"
+)
+
+// varEnvPrefix is the prefix of the environment variables setting variables
+const varEnvPrefix = "ONS_VAR_"
+
+// defaultVarsFiles are the variables files loaded from ONS_PATH if they exist
+var defaultVarsFiles = []string{"ons.vars.json", "ons.vars.yaml", "ons.vars.yml"}
+
+var (
+	varFlags []string
+	varFiles []string
+
+	vars client.Vars
+)
+
+func init() {
+	OnsCmd.PersistentFlags().StringArrayVar(&varFlags, "var", nil,
+		"Set a variable of a templated config (name=value)")
+	OnsCmd.PersistentFlags().StringArrayVar(&varFiles, "var-file", nil,
+		"Load variables of a templated config from a JSON or YAML file")
+}
+
+// loadVars loads the variables of a templated config. By order of precedence,
+// they are set by --var, by the files of --var-file, by the default variables
+// files of ONS_PATH and by the ONS_VAR_<name> environment variables.
+func loadVars() (client.Vars, error) {
+	vars := client.Vars{}
+
+	for _, kv := range os.Environ() {
+		if strings.HasPrefix(kv, varEnvPrefix) {
+			i := strings.Index(kv, "=")
+			vars[kv[len(varEnvPrefix):i]] = kv[i+1:]
+		}
+	}
+
+	files := []string{}
+	for _, name := range defaultVarsFiles {
+		path := filepath.Join(onsDir, name)
+		if _, err := os.Stat(path); err == nil {
+			files = append(files, path)
+		}
+	}
+	files = append(files, varFiles...)
+
+	for _, path := range files {
+		fileVars, err := client.LoadVarsFile(path)
+		if err != nil {
+			return nil, err
+		}
+		for name, value := range fileVars {
+			vars[name] = value
+		}
+	}
+
+	for _, v := range varFlags {
+		i := strings.Index(v, "=")
+		if i <= 0 {
+			return nil, fmt.Errorf("Invalid variable `%s`, expecting name=value", v)
+		}
+		vars[v[:i]] = v[i+1:]
+	}
+
+	return vars, nil
+}
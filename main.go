@@ -1,9 +1,11 @@
 package main
 
 import (
+	"os"
+
 	"github.com/thbkrkr/ons/cmd"
 )
 
 func main() {
-	cmd.OnsCmd.Execute()
+	os.Exit(cmd.Execute())
 }
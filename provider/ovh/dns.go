@@ -0,0 +1,256 @@
+// Package ovh implements an ons DNS provider using the OVH API.
+package ovh
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ovh/go-ovh/ovh"
+	"github.com/thbkrkr/ons/client"
+)
+
+// Defaults of the limits of the calls to the OVH API
+const (
+	DefaultWorkers    = 10
+	DefaultRateLimit  = 20
+	DefaultMaxRetries = 3
+	DefaultBackoff    = 500 * time.Millisecond
+)
+
+// Provider manages DNS zone records with the OVH API
+type Provider struct {
+	client *ovh.Client
+
+	// Workers is the maximum number of concurrent calls to get the records of a zone
+	Workers int
+	// MaxRetries is the maximum number of retries of a call rate limited or
+	// failing with a server error
+	MaxRetries int
+	// Backoff is the delay before the first retry, doubled at each retry
+	Backoff time.Duration
+
+	limiter *rateLimiter
+}
+
+// NewProvider creates a new OVH provider
+func NewProvider(endpoint string, ak string, as string, ck string) (*Provider, error) {
+	ovhClient, err := ovh.NewClient(endpoint, ak, as, ck)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Provider{
+		client:     ovhClient,
+		Workers:    DefaultWorkers,
+		MaxRetries: DefaultMaxRetries,
+		Backoff:    DefaultBackoff,
+		limiter:    newRateLimiter(DefaultRateLimit, DefaultWorkers),
+	}, nil
+}
+
+// SetRateLimit limits the calls to the OVH API to a number of calls per second,
+// allowing bursts of calls. A rate of 0 disables the limit.
+func (p *Provider) SetRateLimit(rate float64, burst int) {
+	p.limiter = newRateLimiter(rate, burst)
+}
+
+// call calls the OVH API once the rate limiter allows it and retries with an
+// exponential backoff when the call is rate limited or fails with a server error.
+// A creation is only retried when rate limited as it may have been done.
+func (p *Provider) call(method string, path string, reqBody interface{}, resType interface{}) error {
+	backoff := p.Backoff
+
+	for attempt := 0; ; attempt++ {
+		p.limiter.wait()
+
+		err := p.client.CallAPI(method, path, reqBody, resType, true)
+		if err == nil || attempt >= p.MaxRetries || !retryable(method, err) {
+			return err
+		}
+
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+// retryable returns true if a call failing with an error can be retried
+func retryable(method string, err error) bool {
+	apiErr, ok := err.(*ovh.APIError)
+	if !ok {
+		return false
+	}
+
+	if apiErr.Code == http.StatusTooManyRequests {
+		return true
+	}
+
+	return method != "POST" && apiErr.Code >= http.StatusInternalServerError
+}
+
+// List lists all DNS zone records
+func (p *Provider) List(zone string) (client.Records, error) {
+	records, err := p.ListRecordsByType(zone, "")
+	if err != nil {
+		return nil, err
+	}
+
+	nbRecords := len(records)
+
+	workers := p.Workers
+	if workers < 1 {
+		workers = 1
+	}
+	if workers > nbRecords {
+		workers = nbRecords
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(workers)
+
+	var mu sync.Mutex
+	var errs []string
+
+	indexes := make(chan int)
+	fullRecords := make([]client.Record, len(records))
+	for w := 0; w < workers; w++ {
+		go func(worker *Provider) {
+			defer wg.Done()
+			for i := range indexes {
+				id := records[i]
+				record, err := worker.Get(zone, id)
+				if err != nil {
+					mu.Lock()
+					errs = append(errs, fmt.Sprintf("record %d: %s", id, err))
+					mu.Unlock()
+					continue
+				}
+				fullRecords[i] = *record
+			}
+		}(p.worker())
+	}
+
+	for i := range records {
+		indexes <- i
+	}
+	close(indexes)
+
+	wg.Wait()
+
+	// A partial list of records would plan to add existing records
+	// and to remove the missing ones
+	if len(errs) > 0 {
+		sort.Strings(errs)
+		return nil, fmt.Errorf("Fail to get %d of %d records of zone `%s`: %s",
+			len(errs), nbRecords, zone, strings.Join(errs, ", "))
+	}
+
+	return fullRecords, nil
+}
+
+// worker returns a copy of the provider with its own OVH client to call the
+// OVH API concurrently: the OVH client sets the timeout of its HTTP client
+// at each call.
+func (p *Provider) worker() *Provider {
+	ovhClient := *p.client
+	httpClient := *p.client.Client
+	ovhClient.Client = &httpClient
+
+	worker := *p
+	worker.client = &ovhClient
+	return &worker
+}
+
+// ListRecordsByType lists all DNS zone records given a type (A, MX, SRV, NS, ...).
+// If the type is empty, records of all types are listed.
+func (p *Provider) ListRecordsByType(zone string, fieldType string) ([]int64, error) {
+	var records []int64
+
+	path := fmt.Sprintf("/domain/zone/%s/record", zone)
+	if fieldType != "" {
+		path += "?fieldType=" + url.QueryEscape(fieldType)
+	}
+
+	err := p.call("GET", path, nil, &records)
+	if err != nil {
+		return nil, err
+	}
+
+	return records, nil
+}
+
+// Get gets a DNS zone record by its ID
+func (p *Provider) Get(zone string, id int64) (*client.Record, error) {
+	var record = &client.Record{}
+
+	err := p.call("GET", fmt.Sprintf("/domain/zone/%s/record/%d", zone, id), nil, record)
+	if err != nil {
+		return nil, err
+	}
+
+	return record, nil
+}
+
+// addRecord represents the request to add a new DNS zone record
+type addRecord struct {
+	FieldType string `json:"fieldType"`
+	SubDomain string `json:"subDomain"`
+	Target    string `json:"target"`
+	TTL       int    `json:"ttl,omitempty"`
+}
+
+// Create creates a new DNS zone record
+func (p *Provider) Create(zone string, r client.Record) (*client.Record, error) {
+	var record = &client.Record{}
+
+	newRecord := &addRecord{FieldType: r.Type(), SubDomain: r.SubDomain, Target: r.Target, TTL: r.TTL}
+	err := p.call("POST", fmt.Sprintf("/domain/zone/%s/record", zone), newRecord, record)
+	if err != nil {
+		return nil, err
+	}
+
+	return record, nil
+}
+
+// updateRecord represents the request to update a DNS zone record
+type updateRecord struct {
+	SubDomain string `json:"subDomain"`
+	Target    string `json:"target"`
+	TTL       int    `json:"ttl"`
+}
+
+// Update updates a DNS zone record given its ID
+func (p *Provider) Update(zone string, r client.Record) error {
+	record := &updateRecord{SubDomain: r.SubDomain, Target: r.Target, TTL: r.TTL}
+
+	err := p.call("PUT", fmt.Sprintf("/domain/zone/%s/record/%d", zone, r.ID), record, nil)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// Delete deletes a DNS zone record given its ID
+func (p *Provider) Delete(zone string, id int64) error {
+	err := p.call("DELETE", fmt.Sprintf("/domain/zone/%s/record/%d", zone, id), nil, nil)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// Refresh applies the DNS zone configuration to DNS servers
+func (p *Provider) Refresh(zone string) error {
+	err := p.call("POST", fmt.Sprintf("/domain/zone/%s/refresh", zone), nil, nil)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,343 @@
+// Package ovhtest provides an in-memory fake of the DNS zone endpoints
+// of the OVH API to test ons without a live OVH endpoint.
+package ovhtest
+
+import (
+	"crypto/sha1"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/thbkrkr/ons/client"
+	"github.com/thbkrkr/ons/provider/ovh"
+)
+
+// Credentials accepted by the server
+const (
+	AppKey      = "ovhtest-ak"
+	AppSecret   = "ovhtest-as"
+	ConsumerKey = "ovhtest-ck"
+)
+
+// record represents a DNS zone record as returned by the OVH API
+type record struct {
+	ID        int64  `json:"id"`
+	Zone      string `json:"zone"`
+	FieldType string `json:"fieldType"`
+	SubDomain string `json:"subDomain"`
+	Target    string `json:"target"`
+	TTL       int    `json:"ttl"`
+}
+
+// failure is an API error returned by the next requests matching a method and a path
+type failure struct {
+	method  string
+	path    string
+	code    int
+	message string
+	times   int
+}
+
+// Server is an HTTP server emulating the DNS zone endpoints of the OVH API:
+// /domain/zone/{zone}/record, /domain/zone/{zone}/record/{id} and
+// /domain/zone/{zone}/refresh. Requests are authenticated like the OVH API does
+// and a CNAME record is rejected when another record has the same name.
+type Server struct {
+	*httptest.Server
+
+	mu        sync.Mutex
+	zones     map[string]map[int64]*record
+	refreshes map[string]int
+	failures  []*failure
+	nextID    int64
+}
+
+// NewServer starts a new server without zones. The caller should call Close when finished.
+func NewServer() *Server {
+	s := &Server{
+		zones:     map[string]map[int64]*record{},
+		refreshes: map[string]int{},
+		nextID:    1000,
+	}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.handle))
+	return s
+}
+
+// Provider returns an OVH provider using the server
+func (s *Server) Provider() (*ovh.Provider, error) {
+	return ovh.NewProvider(s.URL, AppKey, AppSecret, ConsumerKey)
+}
+
+// AddZone adds an empty zone
+func (s *Server) AddZone(zone string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.zones[zone]; !ok {
+		s.zones[zone] = map[int64]*record{}
+	}
+}
+
+// AddRecord adds a record in a zone, creating the zone if needed,
+// and returns it with its ID
+func (s *Server) AddRecord(zone string, r client.Record) client.Record {
+	s.AddZone(zone)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	created := s.create(zone, r.Type(), r.SubDomain, r.Target, r.TTL)
+	return toRecord(created)
+}
+
+// Records returns the records of a zone sorted by ID
+func (s *Server) Records(zone string) client.Records {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	records := client.Records{}
+	for _, id := range s.ids(zone, "", "") {
+		records = append(records, toRecord(s.zones[zone][id]))
+	}
+	return records
+}
+
+// Refreshes returns the number of refreshes of a zone
+func (s *Server) Refreshes(zone string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.refreshes[zone]
+}
+
+// Fail makes the next requests matching a method and a path prefix fail with
+// an API error. The error is returned the given number of times.
+func (s *Server) Fail(method string, path string, code int, message string, times int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.failures = append(s.failures, &failure{method: method, path: path, code: code, message: message, times: times})
+}
+
+func (s *Server) handle(w http.ResponseWriter, req *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	body, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if req.Method == "GET" && req.URL.Path == "/auth/time" {
+		writeJSON(w, time.Now().Unix())
+		return
+	}
+
+	if code, message := s.authenticate(req, body); code != 0 {
+		writeError(w, code, message)
+		return
+	}
+
+	for _, f := range s.failures {
+		if f.times > 0 && f.method == req.Method && strings.HasPrefix(req.URL.Path, f.path) {
+			f.times--
+			writeError(w, f.code, f.message)
+			return
+		}
+	}
+
+	parts := strings.Split(strings.Trim(req.URL.Path, "/"), "/")
+	if len(parts) < 4 || parts[0] != "domain" || parts[1] != "zone" {
+		writeError(w, http.StatusNotFound, "Got an invalid (or empty) URL")
+		return
+	}
+
+	zone := parts[2]
+	if _, ok := s.zones[zone]; !ok {
+		writeError(w, http.StatusNotFound, "This service does not exist")
+		return
+	}
+
+	switch {
+	case len(parts) == 4 && parts[3] == "record":
+		s.handleRecords(w, req, zone, body)
+	case len(parts) == 5 && parts[3] == "record":
+		id, err := strconv.ParseInt(parts[4], 10, 64)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "Invalid id")
+			return
+		}
+		s.handleRecord(w, req, zone, id, body)
+	case len(parts) == 4 && parts[3] == "refresh" && req.Method == "POST":
+		s.refreshes[zone]++
+		writeJSON(w, nil)
+	default:
+		writeError(w, http.StatusNotFound, "Got an invalid (or empty) URL")
+	}
+}
+
+// authenticate checks the authentication headers and the signature of a request
+func (s *Server) authenticate(req *http.Request, body []byte) (int, string) {
+	if req.Header.Get("X-Ovh-Application") != AppKey {
+		return http.StatusForbidden, "Invalid application key"
+	}
+	if req.Header.Get("X-Ovh-Consumer") != ConsumerKey {
+		return http.StatusForbidden, "Invalid credential"
+	}
+
+	timestamp := req.Header.Get("X-Ovh-Timestamp")
+	ts, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return http.StatusBadRequest, "Missing or invalid timestamp"
+	}
+	if delta := time.Now().Unix() - ts; delta > 300 || delta < -300 {
+		return http.StatusUnauthorized, "Query out of time"
+	}
+
+	h := sha1.New()
+	h.Write([]byte(fmt.Sprintf("%s+%s+%s+%s%s+%s+%s",
+		AppSecret, ConsumerKey, req.Method, s.URL, req.URL.RequestURI(), body, timestamp)))
+	if req.Header.Get("X-Ovh-Signature") != fmt.Sprintf("$1$%x", h.Sum(nil)) {
+		return http.StatusBadRequest, "Invalid signature"
+	}
+
+	return 0, ""
+}
+
+func (s *Server) handleRecords(w http.ResponseWriter, req *http.Request, zone string, body []byte) {
+	switch req.Method {
+	case "GET":
+		query := req.URL.Query()
+		writeJSON(w, s.ids(zone, query.Get("fieldType"), query.Get("subDomain")))
+
+	case "POST":
+		var r record
+		if err := json.Unmarshal(body, &r); err != nil {
+			writeError(w, http.StatusBadRequest, "Invalid body")
+			return
+		}
+		if r.FieldType == "" || r.Target == "" {
+			writeError(w, http.StatusBadRequest, "Missing parameter(s): fieldType, target")
+			return
+		}
+		if !client.IsSupportedFieldType(r.FieldType) {
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("Invalid value %s for argument fieldType", r.FieldType))
+			return
+		}
+		// A CNAME record can not coexist with other records of the same name
+		for _, e := range s.zones[zone] {
+			if e.SubDomain == r.SubDomain && (e.FieldType == "CNAME" || r.FieldType == "CNAME") {
+				writeError(w, http.StatusBadRequest, fmt.Sprintf("A CNAME record can not coexist with the %s record %d", e.FieldType, e.ID))
+				return
+			}
+		}
+		writeJSON(w, s.create(zone, r.FieldType, r.SubDomain, r.Target, r.TTL))
+
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+	}
+}
+
+func (s *Server) handleRecord(w http.ResponseWriter, req *http.Request, zone string, id int64, body []byte) {
+	r, ok := s.zones[zone][id]
+	if !ok {
+		writeError(w, http.StatusNotFound, fmt.Sprintf("The requested object (id = %d) does not exist", id))
+		return
+	}
+
+	switch req.Method {
+	case "GET":
+		writeJSON(w, r)
+
+	case "PUT":
+		var update map[string]json.RawMessage
+		if err := json.Unmarshal(body, &update); err != nil {
+			writeError(w, http.StatusBadRequest, "Invalid body")
+			return
+		}
+		updated := *r
+		for field, value := range update {
+			var err error
+			switch field {
+			case "subDomain":
+				err = json.Unmarshal(value, &updated.SubDomain)
+			case "target":
+				err = json.Unmarshal(value, &updated.Target)
+			case "ttl":
+				err = json.Unmarshal(value, &updated.TTL)
+			default:
+				writeError(w, http.StatusBadRequest, fmt.Sprintf("Invalid parameter %s", field))
+				return
+			}
+			if err != nil {
+				writeError(w, http.StatusBadRequest, fmt.Sprintf("Invalid value for parameter %s", field))
+				return
+			}
+		}
+		*r = updated
+		writeJSON(w, nil)
+
+	case "DELETE":
+		delete(s.zones[zone], id)
+		writeJSON(w, nil)
+
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+	}
+}
+
+func (s *Server) create(zone string, fieldType string, subDomain string, target string, ttl int) *record {
+	s.nextID++
+	r := &record{
+		ID:        s.nextID,
+		Zone:      zone,
+		FieldType: fieldType,
+		SubDomain: subDomain,
+		Target:    target,
+		TTL:       ttl,
+	}
+	s.zones[zone][r.ID] = r
+	return r
+}
+
+// ids lists the sorted IDs of the records of a zone matching a type and a sub domain
+func (s *Server) ids(zone string, fieldType string, subDomain string) []int64 {
+	ids := []int64{}
+	for id, r := range s.zones[zone] {
+		if (fieldType == "" || r.FieldType == fieldType) && (subDomain == "" || r.SubDomain == subDomain) {
+			ids = append(ids, id)
+		}
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids
+}
+
+func toRecord(r *record) client.Record {
+	return client.Record{
+		ID:        r.ID,
+		Zone:      r.Zone,
+		FieldType: r.FieldType,
+		SubDomain: r.SubDomain,
+		Target:    r.Target,
+		TTL:       r.TTL,
+	}
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, code int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(map[string]string{"message": message})
+}
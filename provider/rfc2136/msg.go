@@ -0,0 +1,343 @@
+package rfc2136
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strings"
+)
+
+// DNS record types
+const (
+	typeA     uint16 = 1
+	typeNS    uint16 = 2
+	typeCNAME uint16 = 5
+	typeSOA   uint16 = 6
+	typeMX    uint16 = 15
+	typeTXT   uint16 = 16
+	typeAAAA  uint16 = 28
+	typeSRV   uint16 = 33
+	typeTSIG  uint16 = 250
+	typeAXFR  uint16 = 252
+	typeCAA   uint16 = 257
+)
+
+// DNS classes
+const (
+	classIN   uint16 = 1
+	classNONE uint16 = 254
+	classANY  uint16 = 255
+)
+
+// DNS opcodes and response codes
+const (
+	opcodeQuery  = 0
+	opcodeUpdate = 5
+
+	rcodeSuccess = 0
+)
+
+var rcodeNames = map[int]string{
+	1:  "FORMERR",
+	2:  "SERVFAIL",
+	3:  "NXDOMAIN",
+	4:  "NOTIMP",
+	5:  "REFUSED",
+	6:  "YXDOMAIN",
+	7:  "YXRRSET",
+	8:  "NXRRSET",
+	9:  "NOTAUTH",
+	10: "NOTZONE",
+	16: "BADSIG",
+	17: "BADKEY",
+	18: "BADTIME",
+}
+
+var typeNames = map[uint16]string{
+	typeA:     "A",
+	typeNS:    "NS",
+	typeCNAME: "CNAME",
+	typeSOA:   "SOA",
+	typeMX:    "MX",
+	typeTXT:   "TXT",
+	typeAAAA:  "AAAA",
+	typeSRV:   "SRV",
+	typeCAA:   "CAA",
+}
+
+// typeByName returns the DNS record type given its name
+func typeByName(name string) (uint16, bool) {
+	for t, n := range typeNames {
+		if n == name {
+			return t, true
+		}
+	}
+	return 0, false
+}
+
+// question represents an entry of the question (or zone) section
+type question struct {
+	name   string
+	qtype  uint16
+	qclass uint16
+}
+
+// rr represents a resource record. The domain names of the rdata are
+// never compressed.
+type rr struct {
+	name   string
+	rrtype uint16
+	class  uint16
+	ttl    uint32
+	rdata  []byte
+}
+
+// message represents a DNS message. For an UPDATE message, the question,
+// answer and authority sections are the zone, prerequisite and update sections.
+type message struct {
+	id         uint16
+	response   bool
+	opcode     int
+	rcode      int
+	question   []question
+	answer     []rr
+	authority  []rr
+	additional []rr
+
+	// tsigOffset is the offset of the TSIG record of an unpacked message
+	tsigOffset int
+}
+
+func (m *message) pack() ([]byte, error) {
+	flags := uint16(m.opcode&0xf) << 11
+	if m.response {
+		flags |= 1 << 15
+	}
+	flags |= uint16(m.rcode & 0xf)
+
+	buf := make([]byte, 12)
+	binary.BigEndian.PutUint16(buf[0:], m.id)
+	binary.BigEndian.PutUint16(buf[2:], flags)
+	binary.BigEndian.PutUint16(buf[4:], uint16(len(m.question)))
+	binary.BigEndian.PutUint16(buf[6:], uint16(len(m.answer)))
+	binary.BigEndian.PutUint16(buf[8:], uint16(len(m.authority)))
+	binary.BigEndian.PutUint16(buf[10:], uint16(len(m.additional)))
+
+	var err error
+	for _, q := range m.question {
+		buf, err = appendName(buf, q.name)
+		if err != nil {
+			return nil, err
+		}
+		buf = appendUint16(buf, q.qtype)
+		buf = appendUint16(buf, q.qclass)
+	}
+
+	for _, section := range [][]rr{m.answer, m.authority, m.additional} {
+		for _, r := range section {
+			buf, err = appendRR(buf, r)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return buf, nil
+}
+
+func appendRR(buf []byte, r rr) ([]byte, error) {
+	buf, err := appendName(buf, r.name)
+	if err != nil {
+		return nil, err
+	}
+	buf = appendUint16(buf, r.rrtype)
+	buf = appendUint16(buf, r.class)
+	buf = appendUint32(buf, r.ttl)
+	buf = appendUint16(buf, uint16(len(r.rdata)))
+	return append(buf, r.rdata...), nil
+}
+
+func appendUint16(buf []byte, v uint16) []byte {
+	return append(buf, byte(v>>8), byte(v))
+}
+
+func appendUint32(buf []byte, v uint32) []byte {
+	return append(buf, byte(v>>24), byte(v>>16), byte(v>>8), byte(v))
+}
+
+// appendName appends a fully qualified domain name, with or without
+// the trailing dot, in the uncompressed wire format
+func appendName(buf []byte, name string) ([]byte, error) {
+	name = strings.TrimSuffix(name, ".")
+	if name != "" {
+		for _, label := range strings.Split(name, ".") {
+			if len(label) == 0 || len(label) > 63 {
+				return nil, fmt.Errorf("invalid domain name `%s`", name)
+			}
+			buf = append(buf, byte(len(label)))
+			buf = append(buf, label...)
+		}
+	}
+	return append(buf, 0), nil
+}
+
+func unpack(msg []byte) (*message, error) {
+	if len(msg) < 12 {
+		return nil, fmt.Errorf("DNS message too short")
+	}
+
+	flags := binary.BigEndian.Uint16(msg[2:])
+	m := &message{
+		id:       binary.BigEndian.Uint16(msg[0:]),
+		response: flags&(1<<15) != 0,
+		opcode:   int(flags>>11) & 0xf,
+		rcode:    int(flags & 0xf),
+	}
+
+	qdcount := int(binary.BigEndian.Uint16(msg[4:]))
+	counts := []int{
+		int(binary.BigEndian.Uint16(msg[6:])),
+		int(binary.BigEndian.Uint16(msg[8:])),
+		int(binary.BigEndian.Uint16(msg[10:])),
+	}
+
+	off := 12
+	for i := 0; i < qdcount; i++ {
+		name, n, err := readName(msg, off)
+		if err != nil {
+			return nil, err
+		}
+		off = n
+		if off+4 > len(msg) {
+			return nil, fmt.Errorf("DNS message truncated")
+		}
+		m.question = append(m.question, question{
+			name:   name,
+			qtype:  binary.BigEndian.Uint16(msg[off:]),
+			qclass: binary.BigEndian.Uint16(msg[off+2:]),
+		})
+		off += 4
+	}
+
+	sections := []*[]rr{&m.answer, &m.authority, &m.additional}
+	for s, count := range counts {
+		for i := 0; i < count; i++ {
+			start := off
+			r, n, err := readRR(msg, off)
+			if err != nil {
+				return nil, err
+			}
+			off = n
+			if r.rrtype == typeTSIG {
+				m.tsigOffset = start
+			}
+			*sections[s] = append(*sections[s], r)
+		}
+	}
+
+	return m, nil
+}
+
+func readRR(msg []byte, off int) (rr, int, error) {
+	name, off, err := readName(msg, off)
+	if err != nil {
+		return rr{}, 0, err
+	}
+	if off+10 > len(msg) {
+		return rr{}, 0, fmt.Errorf("DNS message truncated")
+	}
+
+	r := rr{
+		name:   name,
+		rrtype: binary.BigEndian.Uint16(msg[off:]),
+		class:  binary.BigEndian.Uint16(msg[off+2:]),
+		ttl:    binary.BigEndian.Uint32(msg[off+4:]),
+	}
+	rdlength := int(binary.BigEndian.Uint16(msg[off+8:]))
+	off += 10
+	end := off + rdlength
+	if end > len(msg) {
+		return rr{}, 0, fmt.Errorf("DNS message truncated")
+	}
+
+	// Decompress the domain names of the rdata
+	var prefix int
+	var names int
+	switch r.rrtype {
+	case typeNS, typeCNAME:
+		prefix, names = 0, 1
+	case typeMX:
+		prefix, names = 2, 1
+	case typeSRV:
+		prefix, names = 6, 1
+	case typeSOA:
+		prefix, names = 0, 2
+	}
+
+	if names == 0 {
+		r.rdata = append([]byte{}, msg[off:end]...)
+		return r, end, nil
+	}
+
+	rdata := append([]byte{}, msg[off:off+prefix]...)
+	n := off + prefix
+	for i := 0; i < names; i++ {
+		var name string
+		name, n, err = readName(msg, n)
+		if err != nil {
+			return rr{}, 0, err
+		}
+		rdata, err = appendName(rdata, name)
+		if err != nil {
+			return rr{}, 0, err
+		}
+	}
+	if n > end {
+		return rr{}, 0, fmt.Errorf("DNS message truncated")
+	}
+	r.rdata = append(rdata, msg[n:end]...)
+
+	return r, end, nil
+}
+
+// readName reads a possibly compressed domain name and returns it without
+// the trailing dot with the offset following the name
+func readName(msg []byte, off int) (string, int, error) {
+	var labels []string
+	next := -1
+	jumps := 0
+
+	for {
+		if off >= len(msg) {
+			return "", 0, fmt.Errorf("DNS message truncated")
+		}
+		length := int(msg[off])
+
+		switch {
+		case length == 0:
+			off++
+			if next < 0 {
+				next = off
+			}
+			return strings.Join(labels, "."), next, nil
+		case length&0xc0 == 0xc0:
+			if off+2 > len(msg) {
+				return "", 0, fmt.Errorf("DNS message truncated")
+			}
+			if next < 0 {
+				next = off + 2
+			}
+			jumps++
+			if jumps > 64 {
+				return "", 0, fmt.Errorf("DNS name compression loop")
+			}
+			off = int(binary.BigEndian.Uint16(msg[off:]) & 0x3fff)
+		default:
+			off++
+			if off+length > len(msg) {
+				return "", 0, fmt.Errorf("DNS message truncated")
+			}
+			labels = append(labels, string(msg[off:off+length]))
+			off += length
+		}
+	}
+}
@@ -0,0 +1,321 @@
+package rfc2136
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// packRData converts the target of a record to its rdata. Relative domain
+// names of the target are relative to the zone.
+func packRData(zone string, rrtype uint16, target string) ([]byte, error) {
+	fields, err := splitFields(target)
+	if err != nil {
+		return nil, err
+	}
+
+	expect := func(n int) error {
+		if len(fields) != n {
+			return fmt.Errorf("invalid %s target `%s`", typeNames[rrtype], target)
+		}
+		return nil
+	}
+
+	switch rrtype {
+	case typeA:
+		if err := expect(1); err != nil {
+			return nil, err
+		}
+		ip := net.ParseIP(fields[0]).To4()
+		if ip == nil {
+			return nil, fmt.Errorf("invalid IPv4 address `%s`", target)
+		}
+		return ip, nil
+
+	case typeAAAA:
+		if err := expect(1); err != nil {
+			return nil, err
+		}
+		ip := net.ParseIP(fields[0])
+		if ip == nil || ip.To4() != nil {
+			return nil, fmt.Errorf("invalid IPv6 address `%s`", target)
+		}
+		return ip.To16(), nil
+
+	case typeCNAME, typeNS:
+		if err := expect(1); err != nil {
+			return nil, err
+		}
+		return appendName(nil, absoluteName(zone, fields[0]))
+
+	case typeMX:
+		if err := expect(2); err != nil {
+			return nil, err
+		}
+		preference, err := parseUint16(fields[0])
+		if err != nil {
+			return nil, err
+		}
+		return appendName(appendUint16(nil, preference), absoluteName(zone, fields[1]))
+
+	case typeSRV:
+		if err := expect(4); err != nil {
+			return nil, err
+		}
+		rdata := []byte{}
+		for _, f := range fields[:3] {
+			v, err := parseUint16(f)
+			if err != nil {
+				return nil, err
+			}
+			rdata = appendUint16(rdata, v)
+		}
+		return appendName(rdata, absoluteName(zone, fields[3]))
+
+	case typeTXT:
+		if len(fields) == 0 {
+			return nil, fmt.Errorf("invalid TXT target `%s`", target)
+		}
+		// An unquoted target is a single string, split in strings of 255 characters
+		if !strings.HasPrefix(target, `"`) {
+			rdata := []byte{}
+			for s := target; s != ""; {
+				n := len(s)
+				if n > 255 {
+					n = 255
+				}
+				rdata = append(rdata, byte(n))
+				rdata = append(rdata, s[:n]...)
+				s = s[n:]
+			}
+			return rdata, nil
+		}
+		rdata := []byte{}
+		for _, f := range fields {
+			if !strings.HasPrefix(f, `"`) {
+				return nil, fmt.Errorf("invalid TXT target `%s`: unquoted string `%s`", target, f)
+			}
+			s := unquote(f)
+			if len(s) > 255 {
+				return nil, fmt.Errorf("TXT string longer than 255 characters")
+			}
+			rdata = append(rdata, byte(len(s)))
+			rdata = append(rdata, s...)
+		}
+		return rdata, nil
+
+	case typeCAA:
+		if err := expect(3); err != nil {
+			return nil, err
+		}
+		flags, err := strconv.ParseUint(fields[0], 10, 8)
+		if err != nil {
+			return nil, fmt.Errorf("invalid CAA flags `%s`", fields[0])
+		}
+		tag := fields[1]
+		rdata := []byte{byte(flags), byte(len(tag))}
+		rdata = append(rdata, tag...)
+		return append(rdata, unquote(fields[2])...), nil
+	}
+
+	return nil, fmt.Errorf("record type `%d` not supported", rrtype)
+}
+
+// unpackRData converts the rdata of a record to its target. Domain names
+// of the target are fully qualified with a trailing dot.
+func unpackRData(rrtype uint16, rdata []byte) (string, error) {
+	invalid := fmt.Errorf("invalid %s rdata", typeNames[rrtype])
+
+	switch rrtype {
+	case typeA, typeAAAA:
+		if (rrtype == typeA && len(rdata) != 4) || (rrtype == typeAAAA && len(rdata) != 16) {
+			return "", invalid
+		}
+		return net.IP(rdata).String(), nil
+
+	case typeCNAME, typeNS:
+		name, _, err := readName(rdata, 0)
+		if err != nil {
+			return "", err
+		}
+		return name + ".", nil
+
+	case typeMX:
+		if len(rdata) < 3 {
+			return "", invalid
+		}
+		name, _, err := readName(rdata, 2)
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("%d %s.", binary.BigEndian.Uint16(rdata), name), nil
+
+	case typeSRV:
+		if len(rdata) < 7 {
+			return "", invalid
+		}
+		name, _, err := readName(rdata, 6)
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("%d %d %d %s.", binary.BigEndian.Uint16(rdata), binary.BigEndian.Uint16(rdata[2:]),
+			binary.BigEndian.Uint16(rdata[4:]), name), nil
+
+	case typeTXT:
+		strs := []string{}
+		for off := 0; off < len(rdata); {
+			length := int(rdata[off])
+			off++
+			if off+length > len(rdata) {
+				return "", invalid
+			}
+			strs = append(strs, string(rdata[off:off+length]))
+			off += length
+		}
+		if target, ok := unquotedTXT(strs); ok {
+			return target, nil
+		}
+		quoted := []string{}
+		for _, s := range strs {
+			quoted = append(quoted, quote(s))
+		}
+		return strings.Join(quoted, " "), nil
+
+	case typeCAA:
+		if len(rdata) < 2 || len(rdata) < 2+int(rdata[1]) {
+			return "", invalid
+		}
+		tagEnd := 2 + int(rdata[1])
+		return fmt.Sprintf("%d %s %s", rdata[0], rdata[2:tagEnd], quote(string(rdata[tagEnd:]))), nil
+	}
+
+	return "", fmt.Errorf("record type `%d` not supported", rrtype)
+}
+
+// unquotedTXT returns the unquoted target of the strings of a TXT record if
+// they are a single string split in strings of 255 characters, the way an
+// unquoted target is packed, and the target can be written unquoted
+func unquotedTXT(strs []string) (string, bool) {
+	if len(strs) == 0 {
+		return "", false
+	}
+	for _, s := range strs[:len(strs)-1] {
+		if len(s) != 255 {
+			return "", false
+		}
+	}
+
+	target := strings.Join(strs, "")
+	if strings.TrimSpace(target) == "" || strings.HasPrefix(target, `"`) {
+		return "", false
+	}
+	for i := 0; i < len(target); i++ {
+		if target[i] < ' ' || target[i] > '~' {
+			return "", false
+		}
+	}
+
+	return target, true
+}
+
+// absoluteName returns a fully qualified name given a name relative to the zone
+func absoluteName(zone string, name string) string {
+	if name == "@" {
+		return zone
+	}
+	if strings.HasSuffix(name, ".") {
+		return name
+	}
+	return name + "." + zone
+}
+
+func parseUint16(value string) (uint16, error) {
+	v, err := strconv.ParseUint(value, 10, 16)
+	if err != nil {
+		return 0, fmt.Errorf("invalid number `%s`", value)
+	}
+	return uint16(v), nil
+}
+
+// splitFields splits a target into fields, keeping quoted strings in one field
+func splitFields(target string) ([]string, error) {
+	var fields []string
+	field := ""
+	inQuotes := false
+
+	for i := 0; i < len(target); i++ {
+		c := target[i]
+		switch {
+		case inQuotes && c == '\\' && i+1 < len(target):
+			field += target[i : i+2]
+			i++
+		case c == '"':
+			inQuotes = !inQuotes
+			field += string(c)
+		case !inQuotes && (c == ' ' || c == '\t'):
+			if field != "" {
+				fields = append(fields, field)
+				field = ""
+			}
+		default:
+			field += string(c)
+		}
+	}
+
+	if inQuotes {
+		return nil, fmt.Errorf("unterminated quoted string in `%s`", target)
+	}
+	if field != "" {
+		fields = append(fields, field)
+	}
+
+	return fields, nil
+}
+
+// unquote removes the quotes of a character string and resolves
+// its escaped characters (\X and \DDD)
+func unquote(s string) string {
+	if len(s) < 2 || s[0] != '"' || s[len(s)-1] != '"' {
+		return s
+	}
+	s = s[1 : len(s)-1]
+
+	out := []byte{}
+	for i := 0; i < len(s); i++ {
+		if s[i] != '\\' || i+1 >= len(s) {
+			out = append(out, s[i])
+			continue
+		}
+		if i+3 < len(s) {
+			if v, err := strconv.Atoi(s[i+1 : i+4]); err == nil && v < 256 {
+				out = append(out, byte(v))
+				i += 3
+				continue
+			}
+		}
+		out = append(out, s[i+1])
+		i++
+	}
+
+	return string(out)
+}
+
+// quote quotes a character string, escaping quotes, backslashes
+// and non printable characters
+func quote(s string) string {
+	out := []byte{'"'}
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == '"' || c == '\\':
+			out = append(out, '\\', c)
+		case c < ' ' || c > '~':
+			out = append(out, fmt.Sprintf("\\%03d", c)...)
+		default:
+			out = append(out, c)
+		}
+	}
+	return string(append(out, '"'))
+}
@@ -0,0 +1,400 @@
+// Package rfc2136 implements an ons DNS provider using dynamic updates
+// (RFC 2136) to modify the records and zone transfers (AXFR) to list them,
+// authenticated with TSIG (RFC 2845).
+package rfc2136
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/thbkrkr/ons/client"
+)
+
+// DefaultTimeout is the timeout of the exchanges with the DNS server
+const DefaultTimeout = 30 * time.Second
+
+// Provider manages DNS zone records of a DNS server supporting dynamic updates.
+// Records have no ID in DNS, their ID is a hash of their sub domain, type and target.
+type Provider struct {
+	server     string
+	key        *tsigKey
+	defaultTTL int
+	timeout    time.Duration
+}
+
+// NewProvider creates a new RFC 2136 provider given the address (host:port) of
+// the DNS server and a TSIG key. If the key name is empty, the requests are not signed.
+// Records created without TTL get the default TTL.
+func NewProvider(server string, keyName string, secret string, algorithm string, defaultTTL int) (*Provider, error) {
+	if _, _, err := net.SplitHostPort(server); err != nil {
+		server = net.JoinHostPort(server, "53")
+	}
+
+	p := &Provider{
+		server:     server,
+		defaultTTL: defaultTTL,
+		timeout:    DefaultTimeout,
+	}
+
+	if keyName != "" {
+		key, err := newTSIGKey(keyName, secret, algorithm)
+		if err != nil {
+			return nil, err
+		}
+		p.key = key
+	}
+
+	return p, nil
+}
+
+// DefaultTTL returns the TTL of the records created without TTL
+func (p *Provider) DefaultTTL() int {
+	return p.defaultTTL
+}
+
+// NormalizeTarget returns the target of a record as listed once created: domain
+// names are fully qualified and a TXT target made of a single quoted string is
+// listed unquoted
+func (p *Provider) NormalizeTarget(record client.Record) string {
+	rrtype, ok := typeByName(record.Type())
+	if !ok || rrtype == typeSOA {
+		return record.Target
+	}
+
+	rdata, err := packRData(record.Zone, rrtype, record.Target)
+	if err != nil {
+		return record.Target
+	}
+
+	target, err := unpackRData(rrtype, rdata)
+	if err != nil {
+		return record.Target
+	}
+
+	return target
+}
+
+// List lists all DNS zone records using a zone transfer
+func (p *Provider) List(zone string) (client.Records, error) {
+	conn, err := p.dial()
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	query := &message{
+		id:       newID(),
+		opcode:   opcodeQuery,
+		question: []question{{name: zone, qtype: typeAXFR, qclass: classIN}},
+	}
+	requestMAC, err := p.send(conn, query)
+	if err != nil {
+		return nil, err
+	}
+
+	records := client.Records{}
+	soa := 0
+	chain := p.tsigChain(requestMAC)
+
+	for soa < 2 {
+		response, err := p.receive(conn, query.id, chain)
+		if err != nil {
+			return nil, fmt.Errorf("Fail to transfer zone `%s`: %s", zone, err)
+		}
+
+		if len(response.answer) == 0 {
+			return nil, fmt.Errorf("Fail to transfer zone `%s`: empty response", zone)
+		}
+
+		for _, r := range response.answer {
+			if r.rrtype == typeSOA {
+				soa++
+				continue
+			}
+
+			record, ok := p.toRecord(zone, r)
+			if ok {
+				records = append(records, record)
+			}
+		}
+	}
+
+	if chain != nil {
+		err = chain.end()
+		if err != nil {
+			return nil, fmt.Errorf("Fail to transfer zone `%s`: %s", zone, err)
+		}
+	}
+
+	return records, nil
+}
+
+// Get gets a DNS zone record by its ID
+func (p *Provider) Get(zone string, id int64) (*client.Record, error) {
+	records, err := p.List(zone)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, r := range records {
+		if r.ID == id {
+			return &r, nil
+		}
+	}
+
+	return nil, fmt.Errorf("Record %d not found in zone `%s`", id, zone)
+}
+
+// Create creates a new DNS zone record
+func (p *Provider) Create(zone string, record client.Record) (*client.Record, error) {
+	add, err := p.toRR(zone, record, classIN)
+	if err != nil {
+		return nil, err
+	}
+
+	err = p.update(zone, []rr{add})
+	if err != nil {
+		return nil, err
+	}
+
+	created, _ := p.toRecord(zone, add)
+	return &created, nil
+}
+
+// Update updates a DNS zone record given its ID by replacing it
+// in a single dynamic update
+func (p *Provider) Update(zone string, record client.Record) error {
+	old, err := p.Get(zone, record.ID)
+	if err != nil {
+		return err
+	}
+
+	del, err := p.toRR(zone, *old, classNONE)
+	if err != nil {
+		return err
+	}
+
+	add, err := p.toRR(zone, record, classIN)
+	if err != nil {
+		return err
+	}
+
+	return p.update(zone, []rr{del, add})
+}
+
+// Delete deletes a DNS zone record given its ID
+func (p *Provider) Delete(zone string, id int64) error {
+	old, err := p.Get(zone, id)
+	if err != nil {
+		return err
+	}
+
+	del, err := p.toRR(zone, *old, classNONE)
+	if err != nil {
+		return err
+	}
+
+	return p.update(zone, []rr{del})
+}
+
+// Refresh does nothing, dynamic updates are applied immediately
+func (p *Provider) Refresh(zone string) error {
+	return nil
+}
+
+// update sends a dynamic update of a zone
+func (p *Provider) update(zone string, updates []rr) error {
+	conn, err := p.dial()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	request := &message{
+		id:        newID(),
+		opcode:    opcodeUpdate,
+		question:  []question{{name: zone, qtype: typeSOA, qclass: classIN}},
+		authority: updates,
+	}
+	requestMAC, err := p.send(conn, request)
+	if err != nil {
+		return err
+	}
+
+	_, err = p.receive(conn, request.id, p.tsigChain(requestMAC))
+	if err != nil {
+		return fmt.Errorf("Fail to update zone `%s`: %s", zone, err)
+	}
+
+	return nil
+}
+
+func (p *Provider) dial() (net.Conn, error) {
+	conn, err := net.DialTimeout("tcp", p.server, p.timeout)
+	if err != nil {
+		return nil, err
+	}
+
+	err = conn.SetDeadline(time.Now().Add(p.timeout))
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	return conn, nil
+}
+
+// send sends a message, signed if a TSIG key is set, and returns its MAC
+func (p *Provider) send(conn net.Conn, m *message) ([]byte, error) {
+	msg, err := m.pack()
+	if err != nil {
+		return nil, err
+	}
+
+	var mac []byte
+	if p.key != nil {
+		msg, mac, err = p.key.sign(msg, nil, nil, time.Now(), false)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	_, err = conn.Write(append(appendUint16(nil, uint16(len(msg))), msg...))
+	if err != nil {
+		return nil, err
+	}
+
+	return mac, nil
+}
+
+// tsigChain returns the chain verifying the responses to a request given its MAC,
+// nil if no TSIG key is set
+func (p *Provider) tsigChain(requestMAC []byte) *tsigChain {
+	if p.key == nil {
+		return nil
+	}
+	return newTSIGChain(p.key, requestMAC)
+}
+
+// receive receives a response and checks its response code. Its signature
+// is verified by the TSIG chain of the request, if set.
+func (p *Provider) receive(conn net.Conn, id uint16, chain *tsigChain) (*message, error) {
+	length := make([]byte, 2)
+	if _, err := io.ReadFull(conn, length); err != nil {
+		return nil, err
+	}
+
+	raw := make([]byte, binary.BigEndian.Uint16(length))
+	if _, err := io.ReadFull(conn, raw); err != nil {
+		return nil, err
+	}
+
+	response, err := unpack(raw)
+	if err != nil {
+		return nil, err
+	}
+
+	if !response.response || response.id != id {
+		return nil, fmt.Errorf("unexpected DNS message")
+	}
+
+	if response.rcode != rcodeSuccess {
+		if name, ok := rcodeNames[response.rcode]; ok {
+			return nil, fmt.Errorf("%s", name)
+		}
+		return nil, fmt.Errorf("response code %d", response.rcode)
+	}
+
+	if chain != nil {
+		err = chain.verify(raw, response)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return response, nil
+}
+
+// toRR converts a record to a resource record of a given class. Resource records
+// of the NONE class delete the matching records in a dynamic update.
+func (p *Provider) toRR(zone string, record client.Record, class uint16) (rr, error) {
+	rrtype, ok := typeByName(record.Type())
+	if !ok || rrtype == typeSOA {
+		return rr{}, fmt.Errorf("Record type `%s` not supported", record.Type())
+	}
+
+	rdata, err := packRData(zone, rrtype, record.Target)
+	if err != nil {
+		return rr{}, err
+	}
+
+	name := zone
+	if record.SubDomain != "" {
+		name = record.SubDomain + "." + zone
+	}
+
+	ttl := record.TTL
+	if ttl == 0 {
+		ttl = p.defaultTTL
+	}
+	if class == classNONE {
+		ttl = 0
+	}
+
+	return rr{name: name, rrtype: rrtype, class: class, ttl: uint32(ttl), rdata: rdata}, nil
+}
+
+// toRecord converts a resource record to a record. It returns false if the type of
+// the resource record is not supported or if it is outside of the zone.
+func (p *Provider) toRecord(zone string, r rr) (client.Record, bool) {
+	fieldType, ok := typeNames[r.rrtype]
+	if !ok || r.rrtype == typeSOA {
+		return client.Record{}, false
+	}
+
+	zone = strings.TrimSuffix(zone, ".")
+	subDomain := ""
+	if !strings.EqualFold(r.name, zone) {
+		suffix := "." + zone
+		if len(r.name) <= len(suffix) || !strings.EqualFold(r.name[len(r.name)-len(suffix):], suffix) {
+			return client.Record{}, false
+		}
+		subDomain = r.name[:len(r.name)-len(suffix)]
+	}
+
+	target, err := unpackRData(r.rrtype, r.rdata)
+	if err != nil {
+		return client.Record{}, false
+	}
+
+	return client.Record{
+		Zone:      zone,
+		ID:        recordID(subDomain, fieldType, target),
+		FieldType: fieldType,
+		SubDomain: subDomain,
+		Target:    target,
+		TTL:       int(r.ttl),
+	}, true
+}
+
+// recordID computes the ID of a record given its sub domain, type and target
+func recordID(subDomain string, fieldType string, target string) int64 {
+	h := fnv.New64a()
+	h.Write([]byte(strings.ToLower(subDomain) + " " + fieldType + " " + target))
+	return int64(h.Sum64() & (1<<63 - 1))
+}
+
+// newID returns a random DNS message ID
+func newID() uint16 {
+	b := make([]byte, 2)
+	if _, err := rand.Read(b); err != nil {
+		return uint16(time.Now().UnixNano())
+	}
+	return binary.BigEndian.Uint16(b)
+}
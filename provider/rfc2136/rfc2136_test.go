@@ -0,0 +1,570 @@
+package rfc2136
+
+import (
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/thbkrkr/ons/client"
+)
+
+const (
+	testZone   = "bada.boum"
+	testKey    = "ons-key"
+	testSecret = "c2VjcmV0c2VjcmV0"
+)
+
+// testServer is an in-process DNS server serving a zone with zone transfers
+// and dynamic updates over TCP, the requests and the responses being signed
+// with a TSIG key. The names of the zone transfers are compressed.
+type testServer struct {
+	listener net.Listener
+	key      *tsigKey
+	// responseKey signs the responses, the key by default
+	responseKey *tsigKey
+	// transferKeys sign the messages of the zone transfers, nil for an
+	// unsigned message, the response key by default
+	transferKeys []*tsigKey
+
+	mu      sync.Mutex
+	records []rr
+	errs    []error
+}
+
+func newTestServer(t *testing.T) *testServer {
+	key, err := newTSIGKey(testKey, testSecret, "hmac-sha256")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := &testServer{listener: listener, key: key, responseKey: key}
+	go s.serve()
+	return s
+}
+
+func (s *testServer) Close() {
+	s.listener.Close()
+}
+
+func (s *testServer) addRecord(t *testing.T, subDomain string, rrtype uint16, target string, ttl uint32) {
+	rdata, err := packRData(testZone, rrtype, target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	name := testZone
+	if subDomain != "" {
+		name = subDomain + "." + testZone
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.records = append(s.records, rr{name: name, rrtype: rrtype, class: classIN, ttl: ttl, rdata: rdata})
+}
+
+// targets returns the targets and the TTLs of the records of a name and a type
+func (s *testServer) targets(t *testing.T, name string, rrtype uint16) map[string]uint32 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	targets := map[string]uint32{}
+	for _, r := range s.records {
+		if strings.EqualFold(r.name, name) && r.rrtype == rrtype {
+			target, err := unpackRData(r.rrtype, r.rdata)
+			if err != nil {
+				t.Fatal(err)
+			}
+			targets[target] = r.ttl
+		}
+	}
+	return targets
+}
+
+func (s *testServer) errors() []error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.errs
+}
+
+func (s *testServer) fail(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.errs = append(s.errs, err)
+}
+
+func (s *testServer) serve() {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		go s.handle(conn)
+	}
+}
+
+func (s *testServer) handle(conn net.Conn) {
+	defer conn.Close()
+
+	for {
+		length := make([]byte, 2)
+		if _, err := io.ReadFull(conn, length); err != nil {
+			return
+		}
+		raw := make([]byte, binary.BigEndian.Uint16(length))
+		if _, err := io.ReadFull(conn, raw); err != nil {
+			return
+		}
+
+		request, err := unpack(raw)
+		if err != nil || len(request.question) != 1 {
+			s.fail(err)
+			return
+		}
+
+		requestMAC, err := s.key.verify(raw, request, nil, nil, false)
+		if err != nil {
+			s.fail(err)
+			s.write(conn, s.response(request, 9), nil)
+			continue
+		}
+
+		q := request.question[0]
+		switch {
+		case q.name != testZone:
+			s.write(conn, s.response(request, 9), requestMAC)
+		case request.opcode == opcodeQuery && q.qtype == typeAXFR:
+			s.transfer(conn, request, requestMAC)
+		case request.opcode == opcodeUpdate && q.qtype == typeSOA:
+			s.update(request)
+			s.write(conn, s.response(request, rcodeSuccess), requestMAC)
+		default:
+			s.write(conn, s.response(request, 4), requestMAC)
+		}
+	}
+}
+
+// response returns a response without records to a request
+func (s *testServer) response(request *message, rcode int) []byte {
+	response := &message{
+		id:       request.id,
+		response: true,
+		opcode:   request.opcode,
+		rcode:    rcode,
+		question: request.question,
+	}
+	msg, err := response.pack()
+	if err != nil {
+		s.fail(err)
+	}
+	return msg
+}
+
+// write writes a message, signed if the MAC of the request is set
+func (s *testServer) write(conn net.Conn, msg []byte, requestMAC []byte) {
+	if requestMAC != nil {
+		var err error
+		msg, _, err = s.responseKey.sign(msg, requestMAC, nil, time.Now(), false)
+		if err != nil {
+			s.fail(err)
+			return
+		}
+	}
+	conn.Write(append(appendUint16(nil, uint16(len(msg))), msg...))
+}
+
+// transfer sends the records of the zone between two SOA records in up to
+// three messages, signed with the transfer keys
+func (s *testServer) transfer(conn net.Conn, request *message, requestMAC []byte) {
+	soa := compressName(testZone)
+	soa = append(soa, compressName("ns1."+testZone)...)
+	soa = append(soa, compressName("hostmaster."+testZone)...)
+	for _, v := range []uint32{1, 3600, 600, 86400, 300} {
+		soa = appendUint32(soa, v)
+	}
+	soaRR := rr{name: testZone, rrtype: typeSOA, class: classIN, ttl: 3600, rdata: soa}
+
+	s.mu.Lock()
+	records := append([]rr{soaRR}, s.records...)
+	keys := s.transferKeys
+	s.mu.Unlock()
+	records = append(records, soaRR)
+
+	size := (len(records) + 2) / 3
+	mac := requestMAC
+	var unsigned []byte
+	for i := 0; len(records) > 0; i++ {
+		n := size
+		if n > len(records) {
+			n = len(records)
+		}
+		msg := s.answer(request, records[:n])
+		records = records[n:]
+
+		key := s.responseKey
+		if keys != nil {
+			key = keys[i]
+		}
+		if key == nil {
+			unsigned = append(unsigned, msg...)
+		} else {
+			var err error
+			msg, mac, err = key.sign(msg, mac, unsigned, time.Now(), i > 0)
+			if err != nil {
+				s.fail(err)
+				return
+			}
+			unsigned = nil
+		}
+		conn.Write(append(appendUint16(nil, uint16(len(msg))), msg...))
+	}
+}
+
+// answer returns a response with records, their names being compressed
+func (s *testServer) answer(request *message, records []rr) []byte {
+	msg := s.response(request, rcodeSuccess)
+	binary.BigEndian.PutUint16(msg[6:], uint16(len(records)))
+
+	for _, r := range records {
+		rdata := r.rdata
+		switch r.rrtype {
+		case typeNS, typeCNAME:
+			rdata = compressRData(rdata, 0)
+		case typeMX:
+			rdata = compressRData(rdata, 2)
+		case typeSRV:
+			rdata = compressRData(rdata, 6)
+		}
+
+		msg = append(msg, compressName(r.name)...)
+		msg = appendUint16(msg, r.rrtype)
+		msg = appendUint16(msg, r.class)
+		msg = appendUint32(msg, r.ttl)
+		msg = appendUint16(msg, uint16(len(rdata)))
+		msg = append(msg, rdata...)
+	}
+	return msg
+}
+
+// update applies the update section of a dynamic update
+func (s *testServer) update(request *message) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, u := range request.authority {
+		records := []rr{}
+		exists := false
+		for _, r := range s.records {
+			if strings.EqualFold(r.name, u.name) && r.rrtype == u.rrtype && string(r.rdata) == string(u.rdata) {
+				exists = true
+				if u.class == classNONE {
+					continue
+				}
+			}
+			records = append(records, r)
+		}
+		if u.class == classIN && !exists {
+			records = append(records, u)
+		}
+		s.records = records
+	}
+}
+
+// compressName packs a name of the zone with a pointer to the zone name of the question
+func compressName(name string) []byte {
+	var buf []byte
+	if name != testZone {
+		for _, label := range strings.Split(strings.TrimSuffix(name, "."+testZone), ".") {
+			buf = append(buf, byte(len(label)))
+			buf = append(buf, label...)
+		}
+	}
+	return append(buf, 0xc0, 12)
+}
+
+// compressRData compresses the domain name of rdata following a prefix
+func compressRData(rdata []byte, prefix int) []byte {
+	name, _, err := readName(rdata, prefix)
+	if err != nil {
+		return rdata
+	}
+	return append(append([]byte{}, rdata[:prefix]...), compressName(name)...)
+}
+
+func newTestProvider(t *testing.T, s *testServer, secret string) *Provider {
+	p, err := NewProvider(s.listener.Addr().String(), testKey, secret, "hmac-sha256", 3600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.timeout = 5 * time.Second
+	return p
+}
+
+func testRecord(subDomain string, fieldType string, target string, ttl int) client.Record {
+	return client.Record{
+		Zone:      testZone,
+		ID:        recordID(subDomain, fieldType, target),
+		SubDomain: subDomain,
+		FieldType: fieldType,
+		Target:    target,
+		TTL:       ttl,
+	}
+}
+
+func TestList(t *testing.T) {
+	s := newTestServer(t)
+	defer s.Close()
+
+	s.addRecord(t, "", typeNS, "ns1.bada.boum.", 3600)
+	s.addRecord(t, "", typeMX, "10 mx.bada.boum.", 3600)
+	s.addRecord(t, "bim", typeA, "1.2.3.4", 3600)
+	s.addRecord(t, "bim", typeAAAA, "2001:db8::1", 3600)
+	s.addRecord(t, "www", typeCNAME, "bim.bada.boum.", 300)
+	s.addRecord(t, "_sip._tcp", typeSRV, "10 5 5060 sip.bada.boum.", 3600)
+	s.addRecord(t, "txt", typeTXT, `"v=spf1 -all"`, 3600)
+
+	p := newTestProvider(t, s, testSecret)
+	records, err := p.List(testZone)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := client.Records{
+		testRecord("", "NS", "ns1.bada.boum.", 3600),
+		testRecord("", "MX", "10 mx.bada.boum.", 3600),
+		testRecord("bim", "A", "1.2.3.4", 3600),
+		testRecord("bim", "AAAA", "2001:db8::1", 3600),
+		testRecord("www", "CNAME", "bim.bada.boum.", 300),
+		testRecord("_sip._tcp", "SRV", "10 5 5060 sip.bada.boum.", 3600),
+		testRecord("txt", "TXT", "v=spf1 -all", 3600),
+	}
+	if !reflect.DeepEqual(records, expected) {
+		t.Errorf("expected %+v, got %+v", expected, records)
+	}
+
+	if errs := s.errors(); len(errs) > 0 {
+		t.Errorf("server errors: %v", errs)
+	}
+}
+
+func TestCreateUpdateDelete(t *testing.T) {
+	s := newTestServer(t)
+	defer s.Close()
+
+	s.addRecord(t, "bim", typeA, "1.2.3.4", 3600)
+
+	p := newTestProvider(t, s, testSecret)
+
+	created, err := p.Create(testZone, client.Record{SubDomain: "bam", FieldType: "CNAME", Target: "bim"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := testRecord("bam", "CNAME", "bim.bada.boum.", 3600); *created != expected {
+		t.Errorf("expected %+v, got %+v", expected, *created)
+	}
+	targets := s.targets(t, "bam.bada.boum", typeCNAME)
+	if !reflect.DeepEqual(targets, map[string]uint32{"bim.bada.boum.": 3600}) {
+		t.Errorf("unexpected records after create: %v", targets)
+	}
+
+	err = p.Update(testZone, client.Record{ID: created.ID, SubDomain: "bam", FieldType: "CNAME", Target: "www.bada.boum.", TTL: 300})
+	if err != nil {
+		t.Fatal(err)
+	}
+	targets = s.targets(t, "bam.bada.boum", typeCNAME)
+	if !reflect.DeepEqual(targets, map[string]uint32{"www.bada.boum.": 300}) {
+		t.Errorf("unexpected records after update: %v", targets)
+	}
+
+	updated := testRecord("bam", "CNAME", "www.bada.boum.", 300)
+	err = p.Delete(testZone, updated.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if targets := s.targets(t, "bam.bada.boum", typeCNAME); len(targets) != 0 {
+		t.Errorf("unexpected records after delete: %v", targets)
+	}
+	if targets := s.targets(t, "bim.bada.boum", typeA); len(targets) != 1 {
+		t.Errorf("unexpected records of bim: %v", targets)
+	}
+
+	if errs := s.errors(); len(errs) > 0 {
+		t.Errorf("server errors: %v", errs)
+	}
+}
+
+func TestRequestSignatureRejected(t *testing.T) {
+	s := newTestServer(t)
+	defer s.Close()
+
+	p := newTestProvider(t, s, "b3RoZXJzZWNyZXQ=")
+	_, err := p.Create(testZone, client.Record{SubDomain: "bam", Target: "1.2.3.5"})
+	if err == nil || !strings.Contains(err.Error(), "NOTAUTH") {
+		t.Errorf("expected a NOTAUTH error, got %v", err)
+	}
+	if targets := s.targets(t, "bam.bada.boum", typeA); len(targets) != 0 {
+		t.Errorf("unexpected records: %v", targets)
+	}
+}
+
+func TestResponseSignatureVerified(t *testing.T) {
+	s := newTestServer(t)
+	defer s.Close()
+
+	otherKey, err := newTSIGKey(testKey, "b3RoZXJzZWNyZXQ=", "hmac-sha256")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.responseKey = otherKey
+	s.addRecord(t, "bim", typeA, "1.2.3.4", 3600)
+
+	p := newTestProvider(t, s, testSecret)
+	_, err = p.List(testZone)
+	if err == nil || !strings.Contains(err.Error(), "invalid TSIG signature") {
+		t.Errorf("expected an invalid signature error, got %v", err)
+	}
+}
+
+func TestTXTRoundTrip(t *testing.T) {
+	long := strings.Repeat("k=rsa; p=MIIBIjANBgkqhkiG9w0BAQEFAAOCAQ8AMIIBCgKCAQEA", 6)
+
+	for _, test := range []struct {
+		target  string
+		strings []string
+		listed  string
+	}{
+		{"v=spf1 include:_spf.example.com -all", []string{"v=spf1 include:_spf.example.com -all"}, "v=spf1 include:_spf.example.com -all"},
+		{long, []string{long[:255], long[255:]}, long},
+		{`"v=spf1 -all"`, []string{"v=spf1 -all"}, "v=spf1 -all"},
+		{`"v=spf1" "-all"`, []string{"v=spf1", "-all"}, `"v=spf1" "-all"`},
+	} {
+		s := newTestServer(t)
+		p := newTestProvider(t, s, testSecret)
+
+		created, err := p.Create(testZone, client.Record{SubDomain: "txt", FieldType: "TXT", Target: test.target})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var strs []string
+		s.mu.Lock()
+		for _, r := range s.records {
+			for off := 0; off < len(r.rdata); off += 1 + int(r.rdata[off]) {
+				strs = append(strs, string(r.rdata[off+1:off+1+int(r.rdata[off])]))
+			}
+		}
+		s.mu.Unlock()
+		if !reflect.DeepEqual(strs, test.strings) {
+			t.Errorf("%s: expected the strings %q, got %q", test.target, test.strings, strs)
+		}
+
+		records, err := p.List(testZone)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(records) != 1 || records[0].Target != test.listed || records[0].ID != created.ID {
+			t.Errorf("%s: expected the target %s listed with ID %d, got %+v", test.target, test.listed, created.ID, records)
+		}
+		if normalized := p.NormalizeTarget(client.Record{Zone: testZone, FieldType: "TXT", Target: test.target}); normalized != test.listed {
+			t.Errorf("%s: expected the target normalized to %s, got %s", test.target, test.listed, normalized)
+		}
+
+		s.Close()
+	}
+}
+
+func TestPlanNormalizedTargets(t *testing.T) {
+	s := newTestServer(t)
+	defer s.Close()
+
+	dir, err := ioutil.TempDir("", "ons")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	configPath := filepath.Join(dir, "ons.config.json")
+	err = ioutil.WriteFile(configPath, []byte(`{"bada.boum": [
+		{"subDomain": "spf", "fieldType": "TXT", "target": "v=spf1 include:_spf.example.com -all"},
+		{"subDomain": "txt", "fieldType": "TXT", "target": "\"v=spf1 -all\""},
+		{"subDomain": "www", "fieldType": "CNAME", "target": "bim"}
+	]}`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := client.NewOnsClient(newTestProvider(t, s, testSecret), client.NewLocalBackend(dir), configPath, "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	changes, err := c.Apply(testZone)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(changes) != 3 {
+		t.Fatalf("expected 3 changes, got %+v", changes)
+	}
+
+	plan, err := c.Plan(testZone)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if plan.HasChanges() {
+		t.Errorf("expected no changes once applied, got %+v", plan)
+	}
+}
+
+func TestTransferSignatures(t *testing.T) {
+	key, err := newTSIGKey(testKey, testSecret, "hmac-sha256")
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherKey, err := newTSIGKey(testKey, "b3RoZXJzZWNyZXQ=", "hmac-sha256")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range []struct {
+		name string
+		keys []*tsigKey
+		err  string
+	}{
+		{"all signed", []*tsigKey{key, key, key}, ""},
+		{"middle unsigned", []*tsigKey{key, nil, key}, ""},
+		{"last unsigned", []*tsigKey{key, key, nil}, "last message not signed"},
+		{"next unsigned", []*tsigKey{key, nil, nil}, "last message not signed"},
+		{"middle forged", []*tsigKey{key, otherKey, key}, "invalid TSIG signature"},
+		{"first unsigned", []*tsigKey{nil, key, key}, "message not signed"},
+	} {
+		s := newTestServer(t)
+		s.transferKeys = test.keys
+		s.addRecord(t, "bim", typeA, "1.2.3.4", 3600)
+		s.addRecord(t, "bam", typeA, "1.2.3.5", 3600)
+		s.addRecord(t, "boum", typeA, "1.2.3.6", 3600)
+		s.addRecord(t, "bada", typeA, "1.2.3.7", 3600)
+
+		records, err := newTestProvider(t, s, testSecret).List(testZone)
+		if test.err == "" {
+			if err != nil {
+				t.Errorf("%s: %s", test.name, err)
+			} else if len(records) != 4 {
+				t.Errorf("%s: expected 4 records, got %+v", test.name, records)
+			}
+		} else if err == nil || !strings.Contains(err.Error(), test.err) {
+			t.Errorf("%s: expected an error `%s`, got %v", test.name, test.err, err)
+		}
+
+		s.Close()
+	}
+}
@@ -0,0 +1,253 @@
+package rfc2136
+
+import (
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"hash"
+	"strings"
+	"time"
+)
+
+// TSIG algorithms (RFC 2845, RFC 4635)
+var tsigAlgorithms = map[string]func() hash.Hash{
+	"hmac-md5.sig-alg.reg.int": md5.New,
+	"hmac-sha1":                sha1.New,
+	"hmac-sha256":              sha256.New,
+	"hmac-sha512":              sha512.New,
+}
+
+const tsigFudge = 300
+
+// tsigKey is a TSIG key used to sign the requests and verify the responses
+type tsigKey struct {
+	name      string
+	algorithm string
+	secret    []byte
+}
+
+func newTSIGKey(name string, secret string, algorithm string) (*tsigKey, error) {
+	algorithm = strings.TrimSuffix(strings.ToLower(algorithm), ".")
+	if algorithm == "hmac-md5" {
+		algorithm = "hmac-md5.sig-alg.reg.int"
+	}
+	if _, ok := tsigAlgorithms[algorithm]; !ok {
+		return nil, fmt.Errorf("TSIG algorithm `%s` not supported", algorithm)
+	}
+
+	key, err := base64.StdEncoding.DecodeString(secret)
+	if err != nil {
+		return nil, fmt.Errorf("invalid TSIG secret: %s", err)
+	}
+
+	return &tsigKey{
+		name:      strings.ToLower(strings.TrimSuffix(name, ".")),
+		algorithm: algorithm,
+		secret:    key,
+	}, nil
+}
+
+// sign appends a TSIG record to a packed message and returns the signed
+// message with its MAC. The prior MAC is the MAC of the request to sign a
+// response. The next messages of a zone transfer are signed with timersOnly,
+// the MAC of the previous signed message and the unsigned messages sent since
+// then (RFC 2845 4.4).
+func (k *tsigKey) sign(msg []byte, priorMAC []byte, unsigned []byte, now time.Time, timersOnly bool) ([]byte, []byte, error) {
+	timeSigned := uint64(now.Unix())
+
+	variables, err := k.variables(timeSigned, tsigFudge, 0, nil, timersOnly)
+	if err != nil {
+		return nil, nil, err
+	}
+	mac := k.mac(priorMAC, append(append([]byte{}, unsigned...), msg...), variables)
+
+	rdata, err := appendName(nil, k.algorithm)
+	if err != nil {
+		return nil, nil, err
+	}
+	rdata = appendUint48(rdata, timeSigned)
+	rdata = appendUint16(rdata, tsigFudge)
+	rdata = appendUint16(rdata, uint16(len(mac)))
+	rdata = append(rdata, mac...)
+	rdata = append(rdata, msg[0:2]...) // original ID
+	rdata = appendUint16(rdata, 0)     // error
+	rdata = appendUint16(rdata, 0)     // other len
+
+	signed, err := appendRR(append([]byte{}, msg...), rr{name: k.name, rrtype: typeTSIG, class: classANY, rdata: rdata})
+	if err != nil {
+		return nil, nil, err
+	}
+	binary.BigEndian.PutUint16(signed[10:], binary.BigEndian.Uint16(signed[10:])+1)
+
+	return signed, mac, nil
+}
+
+// signed returns true if a message has a TSIG record
+func signed(m *message) bool {
+	return len(m.additional) > 0 && m.additional[len(m.additional)-1].rrtype == typeTSIG
+}
+
+// verify verifies the TSIG record of a message and returns its MAC. The prior MAC
+// is the MAC of the request to verify a response. The next messages of a zone
+// transfer are verified with timersOnly, the MAC of the previous signed message
+// and the unsigned messages received since then (RFC 2845 4.4).
+func (k *tsigKey) verify(raw []byte, m *message, priorMAC []byte, unsigned []byte, timersOnly bool) ([]byte, error) {
+	if !signed(m) {
+		return nil, fmt.Errorf("message not signed")
+	}
+	tsig := m.additional[len(m.additional)-1]
+
+	if strings.ToLower(tsig.name) != k.name {
+		return nil, fmt.Errorf("message signed with an unknown key `%s`", tsig.name)
+	}
+
+	algorithm, off, err := readName(tsig.rdata, 0)
+	if err != nil {
+		return nil, err
+	}
+	if off+10 > len(tsig.rdata) {
+		return nil, fmt.Errorf("invalid TSIG record")
+	}
+	timeSigned := readUint48(tsig.rdata[off:])
+	fudge := binary.BigEndian.Uint16(tsig.rdata[off+6:])
+	macSize := int(binary.BigEndian.Uint16(tsig.rdata[off+8:]))
+	off += 10
+	if off+macSize+6 > len(tsig.rdata) {
+		return nil, fmt.Errorf("invalid TSIG record")
+	}
+	mac := tsig.rdata[off : off+macSize]
+	off += macSize
+	originalID := tsig.rdata[off : off+2]
+	tsigError := binary.BigEndian.Uint16(tsig.rdata[off+2:])
+	otherLen := int(binary.BigEndian.Uint16(tsig.rdata[off+4:]))
+	off += 6
+	if off+otherLen > len(tsig.rdata) {
+		return nil, fmt.Errorf("invalid TSIG record")
+	}
+	other := tsig.rdata[off : off+otherLen]
+
+	if tsigError != 0 {
+		return nil, fmt.Errorf("TSIG error %s", rcodeNames[int(tsigError)])
+	}
+	if strings.ToLower(algorithm) != k.algorithm {
+		return nil, fmt.Errorf("message signed with an unexpected algorithm `%s`", algorithm)
+	}
+
+	// The MAC covers the message without the TSIG record, with its original ID
+	msg := append([]byte{}, unsigned...)
+	msg = append(msg, raw[:m.tsigOffset]...)
+	copy(msg[len(unsigned):len(unsigned)+2], originalID)
+	binary.BigEndian.PutUint16(msg[len(unsigned)+10:], uint16(len(m.additional)-1))
+
+	variables, err := k.variables(timeSigned, fudge, tsigError, other, timersOnly)
+	if err != nil {
+		return nil, err
+	}
+	if !hmac.Equal(mac, k.mac(priorMAC, msg, variables)) {
+		return nil, fmt.Errorf("invalid TSIG signature")
+	}
+
+	now := uint64(time.Now().Unix())
+	if now > timeSigned+uint64(fudge) || timeSigned > now+uint64(fudge) {
+		return nil, fmt.Errorf("TSIG signature expired")
+	}
+
+	return append([]byte{}, mac...), nil
+}
+
+// mac computes the MAC of messages and of the TSIG variables
+func (k *tsigKey) mac(priorMAC []byte, msg []byte, variables []byte) []byte {
+	h := hmac.New(tsigAlgorithms[k.algorithm], k.secret)
+
+	if priorMAC != nil {
+		h.Write(appendUint16(nil, uint16(len(priorMAC))))
+		h.Write(priorMAC)
+	}
+	h.Write(msg)
+	h.Write(variables)
+
+	return h.Sum(nil)
+}
+
+// variables returns the TSIG variables covered by the MAC, only the timers
+// for the next messages of a zone transfer
+func (k *tsigKey) variables(timeSigned uint64, fudge uint16, tsigError uint16, other []byte, timersOnly bool) ([]byte, error) {
+	if timersOnly {
+		return appendUint16(appendUint48(nil, timeSigned), fudge), nil
+	}
+
+	variables, err := appendName(nil, k.name)
+	if err != nil {
+		return nil, err
+	}
+	variables = appendUint16(variables, classANY)
+	variables = appendUint32(variables, 0)
+	variables, err = appendName(variables, k.algorithm)
+	if err != nil {
+		return nil, err
+	}
+	variables = appendUint48(variables, timeSigned)
+	variables = appendUint16(variables, fudge)
+	variables = appendUint16(variables, tsigError)
+	variables = appendUint16(variables, uint16(len(other)))
+	return append(variables, other...), nil
+}
+
+// tsigChain verifies the TSIG records of the responses to a signed request.
+// The first response is signed with the MAC of the request. The next messages
+// of a zone transfer are signed with the MAC of the previous signed message;
+// up to 99 messages in a row can be unsigned but the last message must be
+// signed (RFC 2845 4.4).
+type tsigChain struct {
+	key      *tsigKey
+	mac      []byte
+	first    bool
+	unsigned []byte
+	count    int
+}
+
+func newTSIGChain(key *tsigKey, requestMAC []byte) *tsigChain {
+	return &tsigChain{key: key, mac: requestMAC, first: true}
+}
+
+// verify verifies the next message of the chain
+func (c *tsigChain) verify(raw []byte, m *message) error {
+	if !c.first && !signed(m) {
+		c.count++
+		if c.count > 99 {
+			return fmt.Errorf("more than 99 unsigned messages")
+		}
+		c.unsigned = append(c.unsigned, raw...)
+		return nil
+	}
+
+	mac, err := c.key.verify(raw, m, c.mac, c.unsigned, !c.first)
+	if err != nil {
+		return err
+	}
+	c.mac, c.first, c.unsigned, c.count = mac, false, nil, 0
+
+	return nil
+}
+
+// end returns an error if the last message of the chain is not signed
+func (c *tsigChain) end() error {
+	if c.first || c.count > 0 {
+		return fmt.Errorf("last message not signed")
+	}
+	return nil
+}
+
+func appendUint48(buf []byte, v uint64) []byte {
+	return append(buf, byte(v>>40), byte(v>>32), byte(v>>24), byte(v>>16), byte(v>>8), byte(v))
+}
+
+func readUint48(buf []byte) uint64 {
+	return uint64(buf[0])<<40 | uint64(buf[1])<<32 | uint64(buf[2])<<24 |
+		uint64(buf[3])<<16 | uint64(buf[4])<<8 | uint64(buf[5])
+}